@@ -0,0 +1,55 @@
+/* Copyright 2013 Robert Zaremba
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package contentnegotiator
+
+import (
+	"encoding/json"
+
+	"github.com/ugorji/go/codec"
+)
+
+// Codec encodes and decodes values in a given content type.
+// Renderer uses them for the responses, and other packages (eg. sse) reuse
+// them, so the values are encoded the same way everywhere.
+type Codec interface {
+	ContentType() string
+	Marshal(v interface{}) ([]byte, error)
+	Unmarshal(data []byte, v interface{}) error
+}
+
+var (
+	JSON    Codec = jsonCodec{}
+	Msgpack Codec = msgpackCodec{}
+)
+
+type jsonCodec struct{}
+
+func (jsonCodec) ContentType() string                        { return "application/json" }
+func (jsonCodec) Marshal(v interface{}) ([]byte, error)      { return json.Marshal(v) }
+func (jsonCodec) Unmarshal(data []byte, v interface{}) error { return json.Unmarshal(data, v) }
+
+type msgpackCodec struct{}
+
+func (msgpackCodec) ContentType() string { return "application/x-msgpack" }
+
+func (msgpackCodec) Marshal(v interface{}) (b []byte, err error) {
+	err = codec.NewEncoderBytes(&b, &msgpackHandle).Encode(v)
+	return
+}
+
+func (msgpackCodec) Unmarshal(data []byte, v interface{}) error {
+	return codec.NewDecoderBytes(data, &msgpackHandle).Decode(v)
+}
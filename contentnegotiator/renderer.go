@@ -16,12 +16,12 @@
 package contentnegotiator
 
 import (
-	"encoding/json"
 	"fmt"
 	"html/template"
 	"net/http"
 	"strconv"
 	"strings"
+	"sync"
 
 	"github.com/ugorji/go/codec"
 )
@@ -51,7 +51,8 @@ type Renderer struct {
 	Log Logger
 	/* handler, which output will be rendered. It should return
 	 * data to be rendered. data is an error, then http.Error will be used to render it.
-	 * status code */
+	 * status code
+	 * The caller authenticated by handlers.Auth is handlers.GetPrincipal(r). */
 	H HandlerRend
 }
 
@@ -63,15 +64,13 @@ func (this Renderer) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 	}
 	switch negotiateRenderer(r.Header.Get("Accept")) {
 	case r_json:
-		w.Header().Set("Content-Type", "application/json")
-		content, err := json.Marshal(data)
+		w.Header().Set("Content-Type", JSON.ContentType())
+		content, err := JSON.Marshal(data)
 		write(this.Log, w, content, err, status)
 	case r_msgpack:
-		w.Header().Set("Content-Type", "application/x-msgpack")
-		w.WriteHeader(status)
-		err := codec.NewEncoder(w, &msgpackHandle).
-			Encode(data)
-		writeError(this.Log, w, err)
+		w.Header().Set("Content-Type", Msgpack.ContentType())
+		content, err := Msgpack.Marshal(data)
+		write(this.Log, w, content, err, status)
 	default:
 		w.Header().Set("Content-Type", "text/plain")
 		write(this.Log, w, []byte(fmt.Sprint(data)), nil, status)
@@ -86,21 +85,55 @@ type TRenderer struct {
 	/* handler, which output will be rendered. It should return
 	* template name which is a fielname associated to `T`.
 	* data to be rendered. data is an error, then http.Error will be used to render it.
-	* status code */
+	* status code
+	* The caller authenticated by handlers.Auth is handlers.GetPrincipal(r). */
 	H func(w http.ResponseWriter, r *http.Request) (string, interface{}, int)
 }
 
 func (this TRenderer) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	this.render(w, r, this.T)
+}
+
+func (this TRenderer) render(w http.ResponseWriter, r *http.Request, t *template.Template) {
 	tname, data, status := this.H(w, r)
 	if dataErr, ok := data.(error); ok {
 		http.Error(w, dataErr.Error(), status)
 	}
 	w.Header().Set("Content-Type", "text/html")
-	if err := this.T.ExecuteTemplate(w, tname, data); err != nil {
+	if err := t.ExecuteTemplate(w, tname, data); err != nil {
 		write(this.Log, w, nil, err, status)
 	}
 }
 
+// WithFuncs returns a handler executing the templates with the functions of
+// the request, eg. handlers.CSRFFuncs. They replace the functions `T` was
+// parsed with. The templates are executed on clones of `T`, made as needed
+// and reused, so `T` itself must not be executed. WithFuncs panics if `T`
+// was executed already.
+func (this TRenderer) WithFuncs(funcs func(r *http.Request) template.FuncMap) http.Handler {
+	first := template.Must(this.T.Clone())
+	h := &funcsRenderer{TRenderer: this, funcs: funcs}
+	h.clones.New = func() interface{} {
+		// T is never executed, so it can always be cloned
+		return template.Must(this.T.Clone())
+	}
+	h.clones.Put(first)
+	return h
+}
+
+// funcsRenderer is a TRenderer with functions of the request.
+type funcsRenderer struct {
+	TRenderer
+	funcs  func(r *http.Request) template.FuncMap
+	clones sync.Pool
+}
+
+func (this *funcsRenderer) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	t := this.clones.Get().(*template.Template)
+	defer this.clones.Put(t)
+	this.render(w, r, t.Funcs(this.funcs(r)))
+}
+
 func write(logger Logger, w http.ResponseWriter, data []byte, err error, status int) {
 	writeError(logger, w, err)
 	w.Header().Set("Content-Length", strconv.Itoa(len(data)))
@@ -9,7 +9,6 @@ package main
 import (
 	"bufio"
 	"compress/gzip"
-	"encoding/json"
 	"html"
 	"log"
 	"net/http"
@@ -33,16 +32,17 @@ func SSEHandler(w http.ResponseWriter, r *http.Request) {
 	if sf < 0 || sf >= cap(frames) {
 		sf = 0
 	}
-	conn, buf, err := sse.ServeEvents(w)
+	stream, err := sse.NewStream(w, r)
 	if err != nil {
 		log.Println(err)
 		return
 	}
-	defer conn.Close()
 	// Play the movie, frame by frame
 	for n, f := range frames[sf:] {
-		m := &sse.MessageEvent{Id: strconv.Itoa(n + 1), Data: f.Buf}
-		e := sse.SendEvent(buf, m)
+		// the frame is encoded once, only the id is set per stream
+		m := *f.Event
+		m.Id = strconv.Itoa(n + 1)
+		e := stream.Send(&m)
 		if e != nil {
 			// usually a broken pipe error
 			// log.Println(e.Error())
@@ -72,8 +72,8 @@ type Message struct {
 }
 
 type Frame struct {
-	Time time.Duration
-	Buf  string // This is a JSON-encoded Message{FrameBuf:...}
+	Time  time.Duration
+	Event *sse.MessageEvent // This is a JSON-encoded Message{FrameBuf:...}
 }
 
 var frames []Frame
@@ -102,8 +102,11 @@ func loadMovie(filename string) error {
 		switch lineno % 14 {
 		case 0:
 			b := html.EscapeString(frameBuf + part)
-			j, _ := json.Marshal(Message{frameNo, b})
-			frames = append(frames, Frame{frameTime, string(j)})
+			m, err := sse.JSONEvent("", Message{frameNo, b})
+			if err != nil {
+				return err
+			}
+			frames = append(frames, Frame{frameTime, m})
 			frameNo++
 			frameBuf = ""
 		case 1:
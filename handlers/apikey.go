@@ -0,0 +1,307 @@
+package handlers
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"net/http"
+	"os"
+	"strings"
+	"sync"
+	"time"
+)
+
+// DefaultAPIKeyHeader is the header carrying the key when APIKeyAuth.Header
+// is empty.
+const DefaultAPIKeyHeader = "X-API-Key"
+
+// APIKey is a key given to a client. Only the hash of the key is stored.
+type APIKey struct {
+	ID     string   `json:"id"`
+	Hash   string   `json:"hash"`
+	Scopes []string `json:"scopes,omitempty"`
+	// Expires is the expiry time of the key. The key does not expire if zero.
+	Expires  time.Time `json:"expires"`
+	Revoked  bool      `json:"revoked,omitempty"`
+	LastUsed time.Time `json:"last_used"`
+}
+
+// Valid checks that the key is neither revoked nor expired.
+func (k *APIKey) Valid(now time.Time) bool {
+	return !k.Revoked && (k.Expires.IsZero() || now.Before(k.Expires))
+}
+
+// HashAPIKey returns the hash of the key stored in APIKey.Hash. The keys
+// are random, so a fast hash is enough.
+func HashAPIKey(key string) string {
+	sum := sha256.Sum256([]byte(key))
+	return hex.EncodeToString(sum[:])
+}
+
+// GenerateAPIKey returns a new random key, to give to the client, and its
+// hash, to store.
+func GenerateAPIKey() (key, hash string, err error) {
+	b := make([]byte, 32)
+	if _, err = rand.Read(b); err != nil {
+		return "", "", err
+	}
+	key = base64.RawURLEncoding.EncodeToString(b)
+	return key, HashAPIKey(key), nil
+}
+
+// APIKeyStore stores the API keys by hash.
+type APIKeyStore interface {
+	// Lookup returns a copy of the key with the hash.
+	Lookup(hash string) (APIKey, bool)
+	// Used records that the key with the id was used at t.
+	Used(id string, t time.Time)
+}
+
+// MemoryKeyStore is an APIKeyStore in memory. It must be created with
+// NewMemoryKeyStore.
+type MemoryKeyStore struct {
+	mu   sync.RWMutex
+	keys map[string]*APIKey // by hash
+	ids  map[string]*APIKey
+}
+
+// NewMemoryKeyStore returns a store holding the keys.
+func NewMemoryKeyStore(keys ...APIKey) *MemoryKeyStore {
+	s := &MemoryKeyStore{}
+	s.set(keys)
+	return s
+}
+
+func (s *MemoryKeyStore) set(keys []APIKey) {
+	s.keys = make(map[string]*APIKey, len(keys))
+	s.ids = make(map[string]*APIKey, len(keys))
+	for i := range keys {
+		k := keys[i]
+		s.keys[k.Hash] = &k
+		s.ids[k.ID] = &k
+	}
+}
+
+// Add adds or replaces the key with the same ID.
+func (s *MemoryKeyStore) Add(key APIKey) {
+	s.mu.Lock()
+	if old, ok := s.ids[key.ID]; ok {
+		delete(s.keys, old.Hash)
+	}
+	s.keys[key.Hash] = &key
+	s.ids[key.ID] = &key
+	s.mu.Unlock()
+}
+
+// Revoke revokes the key with the id. It returns false if there is none.
+func (s *MemoryKeyStore) Revoke(id string) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	k, ok := s.ids[id]
+	if ok {
+		k.Revoked = true
+	}
+	return ok
+}
+
+// Keys returns a copy of all the keys.
+func (s *MemoryKeyStore) Keys() []APIKey {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	keys := make([]APIKey, 0, len(s.ids))
+	for _, k := range s.ids {
+		keys = append(keys, *k)
+	}
+	return keys
+}
+
+func (s *MemoryKeyStore) Lookup(hash string) (APIKey, bool) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	if k, ok := s.keys[hash]; ok {
+		return *k, true
+	}
+	return APIKey{}, false
+}
+
+func (s *MemoryKeyStore) Used(id string, t time.Time) {
+	s.mu.Lock()
+	if k, ok := s.ids[id]; ok && t.After(k.LastUsed) {
+		k.LastUsed = t
+	}
+	s.mu.Unlock()
+}
+
+// FileKeyStore is an APIKeyStore backed by a JSON file holding an array of
+// APIKey. Add and Revoke write the file; the last used times are kept in
+// memory and written by Save, to call periodically or on shutdown.
+//
+// Usage example:
+//
+//	keys, err := handlers.OpenKeyFile("/var/lib/app/apikeys.json")
+//	...
+//	auth := handlers.APIKeyAuth{Store: keys}
+//	http.Handle("/partners/", auth.Handler(handlers.RequireScope("orders:read", ordersHandler)))
+type FileKeyStore struct {
+	MemoryKeyStore
+	path string
+	// file serializes the writes of the file
+	file sync.Mutex
+}
+
+// OpenKeyFile loads the key file. A missing file is created on the first
+// write.
+func OpenKeyFile(path string) (*FileKeyStore, error) {
+	s := &FileKeyStore{path: path}
+	s.set(nil)
+	if err := s.Reload(); err != nil && !os.IsNotExist(err) {
+		return nil, err
+	}
+	return s, nil
+}
+
+// Reload reads the file again, eg. after it was edited. The last used
+// times recorded since are kept.
+func (s *FileKeyStore) Reload() error {
+	data, err := os.ReadFile(s.path)
+	if err != nil {
+		return err
+	}
+	var keys []APIKey
+	if err := json.Unmarshal(data, &keys); err != nil {
+		return err
+	}
+	s.mu.Lock()
+	for i, k := range keys {
+		if old, ok := s.ids[k.ID]; ok && old.LastUsed.After(k.LastUsed) {
+			keys[i].LastUsed = old.LastUsed
+		}
+	}
+	s.set(keys)
+	s.mu.Unlock()
+	return nil
+}
+
+// Add adds or replaces the key with the same ID, and writes the file.
+func (s *FileKeyStore) Add(key APIKey) error {
+	s.MemoryKeyStore.Add(key)
+	return s.Save()
+}
+
+// Revoke revokes the key with the id, and writes the file.
+func (s *FileKeyStore) Revoke(id string) (bool, error) {
+	if !s.MemoryKeyStore.Revoke(id) {
+		return false, nil
+	}
+	return true, s.Save()
+}
+
+// Save writes the keys to the file, atomically.
+func (s *FileKeyStore) Save() error {
+	s.file.Lock()
+	defer s.file.Unlock()
+	data, err := json.MarshalIndent(s.Keys(), "", "  ")
+	if err != nil {
+		return err
+	}
+	tmp := s.path + ".tmp"
+	if err := os.WriteFile(tmp, data, 0600); err != nil {
+		return err
+	}
+	return os.Rename(tmp, s.path)
+}
+
+// APIKeyAuth authenticates the requests with an API key, sent in a header or
+// in a query parameter. The principal of the authenticated requests, see
+// GetPrincipal, has the key ID as ID and the key scopes as "scopes" claim,
+// checked by RequireScope.
+type APIKeyAuth struct {
+	Store APIKeyStore
+	// Header is the header carrying the key, DefaultAPIKeyHeader if empty.
+	Header string
+	// Query, if set, is the query parameter carrying the key when there is
+	// no header. Query strings end up in logs, prefer the header.
+	Query string
+}
+
+// Principal is a PrincipalAuthenticator checking the API key of the request.
+func (this APIKeyAuth) Principal(req *http.Request) (*Principal, bool) {
+	header := this.Header
+	if header == "" {
+		header = DefaultAPIKeyHeader
+	}
+	key := req.Header.Get(header)
+	if key == "" && this.Query != "" {
+		key = req.URL.Query().Get(this.Query)
+	}
+	if key == "" {
+		return nil, false
+	}
+	k, ok := this.Store.Lookup(HashAPIKey(key))
+	now := time.Now()
+	if !ok || !k.Valid(now) {
+		return nil, false
+	}
+	this.Store.Used(k.ID, now)
+	return &Principal{ID: k.ID, Claims: map[string]interface{}{"scopes": k.Scopes}}, true
+}
+
+// Challenge answers 401 Unauthorized with a JSON error. It is the Fallback
+// of Handler.
+func (this APIKeyAuth) Challenge(w http.ResponseWriter, req *http.Request) {
+	writeAuthError(w, http.StatusUnauthorized, AuthError{Error: "invalid_api_key", Message: "Missing, invalid, expired or revoked API key"})
+}
+
+// Handler returns an Auth handler protecting next.
+func (this APIKeyAuth) Handler(next http.Handler) Auth {
+	return Auth{P: this.Principal, Next: next, Fallback: http.HandlerFunc(this.Challenge)}
+}
+
+// AuthError is the JSON body of the authentication and authorization
+// errors of APIKeyAuth and RequireScope.
+type AuthError struct {
+	Error   string `json:"error"`
+	Message string `json:"message"`
+	Scope   string `json:"scope,omitempty"`
+}
+
+func writeAuthError(w http.ResponseWriter, status int, e AuthError) {
+	w.Header().Set("Content-Type", "application/json; charset=utf-8")
+	w.Header().Set("X-Content-Type-Options", "nosniff")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(e)
+}
+
+// RequireScope returns a handler answering 403 Forbidden with a JSON
+// AuthError, unless the principal of the request has the scope. It goes
+// behind an Auth handler. The scopes are the "scopes" claim, as set by
+// APIKeyAuth, or the space separated "scope" claim of OAuth 2 tokens, as
+// verified by JWTAuth.
+func RequireScope(scope string, next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		if p, ok := GetPrincipal(req); ok && hasScope(p, scope) {
+			next.ServeHTTP(w, req)
+			return
+		}
+		writeAuthError(w, http.StatusForbidden, AuthError{
+			Error:   "insufficient_scope",
+			Message: "The credentials lack the " + scope + " scope",
+			Scope:   scope,
+		})
+	})
+}
+
+func hasScope(p *Principal, scope string) bool {
+	scopes, _ := Claim[[]string](p, "scopes")
+	if s, ok := Claim[string](p, "scope"); ok {
+		scopes = append(scopes, strings.Fields(s)...)
+	}
+	for _, s := range scopes {
+		if s == scope {
+			return true
+		}
+	}
+	return false
+}
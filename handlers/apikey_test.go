@@ -0,0 +1,105 @@
+package handlers
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestAPIKeyAuth(t *testing.T) {
+	key, hash, err := GenerateAPIKey()
+	if err != nil {
+		t.Fatal(err)
+	}
+	store := NewMemoryKeyStore(
+		APIKey{ID: "partner", Hash: hash, Scopes: []string{"orders:read"}},
+		APIKey{ID: "expired", Hash: HashAPIKey("expired"), Expires: time.Now().Add(-time.Hour)},
+		APIKey{ID: "revoked", Hash: HashAPIKey("revoked")},
+	)
+	store.Revoke("revoked")
+	auth := APIKeyAuth{Store: store, Query: "api_key"}
+	mux := http.NewServeMux()
+	mux.Handle("/orders", RequireScope("orders:read", http.HandlerFunc(whoami)))
+	mux.Handle("/admin", RequireScope("admin", http.HandlerFunc(whoami)))
+	h := auth.Handler(mux)
+
+	for _, c := range []struct {
+		url, header string
+		status      int
+		error       string
+	}{
+		{"/orders", key, 200, ""},
+		{"/orders?api_key=" + key, "", 200, ""},
+		{"/admin", key, 403, "insufficient_scope"},
+		{"/orders", "wrong", 401, "invalid_api_key"},
+		{"/orders", "expired", 401, "invalid_api_key"},
+		{"/orders", "revoked", 401, "invalid_api_key"},
+		{"/orders", "", 401, "invalid_api_key"},
+	} {
+		req := httptest.NewRequest("GET", c.url, nil)
+		if c.header != "" {
+			req.Header.Set("X-API-Key", c.header)
+		}
+		w := httptest.NewRecorder()
+		h.ServeHTTP(w, req)
+		if w.Code != c.status {
+			t.Errorf("%s %q: got %d, want %d", c.url, c.header, w.Code, c.status)
+			continue
+		}
+		if c.status == 200 {
+			if w.Body.String() != "partner" {
+				t.Errorf("%s: got %q", c.url, w.Body)
+			}
+			continue
+		}
+		var e AuthError
+		if err := json.Unmarshal(w.Body.Bytes(), &e); err != nil || e.Error != c.error {
+			t.Errorf("%s %q: got %q, %v", c.url, c.header, w.Body, err)
+		}
+	}
+
+	k, _ := store.Lookup(hash)
+	if time.Since(k.LastUsed) > time.Minute {
+		t.Errorf("last used %v", k.LastUsed)
+	}
+}
+
+func TestRequireScopeJWT(t *testing.T) {
+	req := WithPrincipal(httptest.NewRequest("GET", "/", nil), &Principal{ID: "alice", Claims: map[string]interface{}{"scope": "read write"}})
+	w := httptest.NewRecorder()
+	RequireScope("write", http.HandlerFunc(whoami)).ServeHTTP(w, req)
+	if w.Code != 200 {
+		t.Errorf("got %d", w.Code)
+	}
+}
+
+func TestFileKeyStore(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "keys.json")
+	store, err := OpenKeyFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := store.Add(APIKey{ID: "partner", Hash: HashAPIKey("key"), Scopes: []string{"orders:read"}}); err != nil {
+		t.Fatal(err)
+	}
+	used := time.Now().Truncate(time.Second)
+	store.Used("partner", used)
+	if err := store.Save(); err != nil {
+		t.Fatal(err)
+	}
+	if ok, err := store.Revoke("partner"); !ok || err != nil {
+		t.Fatal(ok, err)
+	}
+
+	store, err = OpenKeyFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	k, ok := store.Lookup(HashAPIKey("key"))
+	if !ok || !k.Revoked || !k.LastUsed.Equal(used) || k.Scopes[0] != "orders:read" {
+		t.Errorf("got %+v %v", k, ok)
+	}
+}
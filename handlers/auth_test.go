@@ -0,0 +1,143 @@
+package handlers
+
+import (
+	"crypto/md5"
+	"crypto/sha256"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"golang.org/x/crypto/bcrypt"
+)
+
+func whoami(w http.ResponseWriter, r *http.Request) {
+	p, _ := GetPrincipal(r)
+	fmt.Fprint(w, p.ID)
+}
+
+func TestBasicAuth(t *testing.T) {
+	hash, _ := bcrypt.GenerateFromPassword([]byte("secret"), bcrypt.MinCost)
+	// {SHA} of "password", as written by htpasswd -s
+	file := filepath.Join(t.TempDir(), "htpasswd")
+	content := "# users\nalice:" + strings.Replace(string(hash), "$2a$", "$2y$", 1) + "\nbob:{SHA}W6ph5Mm5Pz8GgiULbPgzG37mj9g=\n"
+	if err := os.WriteFile(file, []byte(content), 0600); err != nil {
+		t.Fatal(err)
+	}
+	users, err := LoadHtpasswd(file)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, store := range []CredentialStore{users, Credentials{"alice": "secret", "bob": "password"}} {
+		h := BasicAuth{Realm: "admin", Store: store}.Handler(http.HandlerFunc(whoami))
+		for _, c := range []struct {
+			user, password string
+			status         int
+		}{
+			{"alice", "secret", 200},
+			{"bob", "password", 200},
+			{"alice", "password", 401},
+			{"carol", "secret", 401},
+			{"", "", 401},
+		} {
+			req := httptest.NewRequest("GET", "/", nil)
+			if c.user != "" {
+				req.SetBasicAuth(c.user, c.password)
+			}
+			w := httptest.NewRecorder()
+			h.ServeHTTP(w, req)
+			if w.Code != c.status {
+				t.Errorf("%T %s: expected status %d, got %d", store, c.user, c.status, w.Code)
+			}
+			if c.status == 200 && w.Body.String() != c.user {
+				t.Errorf("%T %s: unexpected identity %q", store, c.user, w.Body)
+			}
+			if c.status == 401 && w.Header().Get("WWW-Authenticate") != `Basic realm="admin", charset="UTF-8"` {
+				t.Errorf("unexpected challenge %q", w.Header().Get("WWW-Authenticate"))
+			}
+		}
+	}
+
+	os.WriteFile(file, []byte("alice:$apr1$xyz$abc\n"), 0600)
+	if _, err := LoadHtpasswd(file); err == nil {
+		t.Error("expected MD5 hashes to be rejected")
+	}
+}
+
+func TestDigestAuth(t *testing.T) {
+	d := NewDigestAuth("api", Credentials{"Mufasa": "Circle of Life"})
+	h := d.Handler(http.HandlerFunc(whoami))
+
+	w := httptest.NewRecorder()
+	h.ServeHTTP(w, httptest.NewRequest("GET", "/dir/index.html", nil))
+	challenges := w.Header().Values("WWW-Authenticate")
+	if w.Code != 401 || len(challenges) != 2 || !strings.HasPrefix(challenges[0], `Digest realm="api", qop="auth", algorithm=SHA-256`) {
+		t.Fatalf("unexpected challenge %d %q", w.Code, challenges)
+	}
+	nonce := parseAuthParams(strings.TrimPrefix(challenges[0], "Digest "))["nonce"]
+
+	authorize := func(algorithm, password, nonce string) *httptest.ResponseRecorder {
+		hex := func(s string) string {
+			if algorithm == "MD5" {
+				return fmt.Sprintf("%x", md5.Sum([]byte(s)))
+			}
+			return fmt.Sprintf("%x", sha256.Sum256([]byte(s)))
+		}
+		ha1 := hex("Mufasa:api:" + password)
+		ha2 := hex("GET:/dir/index.html")
+		response := hex(ha1 + ":" + nonce + ":00000001:0a4f113b:auth:" + ha2)
+		req := httptest.NewRequest("GET", "/dir/index.html", nil)
+		req.Header.Set("Authorization", fmt.Sprintf(`Digest username="Mufasa", realm="api", nonce="%s", `+
+			`uri="/dir/index.html", algorithm=%s, qop=auth, nc=00000001, cnonce="0a4f113b", response="%s"`,
+			nonce, algorithm, response))
+		w := httptest.NewRecorder()
+		h.ServeHTTP(w, req)
+		return w
+	}
+	for _, algorithm := range []string{"SHA-256", "MD5"} {
+		if w := authorize(algorithm, "Circle of Life", nonce); w.Code != 200 || w.Body.String() != "Mufasa" {
+			t.Errorf("%s: unexpected response %d %q", algorithm, w.Code, w.Body)
+		}
+	}
+	if w := authorize("SHA-256", "wrong", nonce); w.Code != 401 || strings.Contains(w.Header().Get("WWW-Authenticate"), "stale") {
+		t.Errorf("unexpected response to a wrong password %d %v", w.Code, w.Header())
+	}
+	old := d.nonce(time.Now().Add(-time.Hour))
+	if w := authorize("SHA-256", "Circle of Life", old); w.Code != 401 || !strings.Contains(w.Header().Get("WWW-Authenticate"), "stale=true") {
+		t.Errorf("expected a stale nonce, got %d %v", w.Code, w.Header())
+	}
+}
+
+func TestPrincipal(t *testing.T) {
+	h := Auth{
+		P: func(r *http.Request) (*Principal, bool) {
+			return &Principal{ID: "alice", Roles: []string{"admin"}, Claims: map[string]interface{}{"tenant": "acme"}}, true
+		},
+		Next: http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			p, ok := GetPrincipal(r)
+			if !ok || p.ID != "alice" || !p.HasRole("admin") || p.HasRole("root") {
+				t.Errorf("unexpected principal %+v", p)
+			}
+			if tenant, ok := Claim[string](p, "tenant"); !ok || tenant != "acme" {
+				t.Errorf("unexpected tenant claim %q", tenant)
+			}
+			if _, ok := Claim[int](p, "tenant"); ok {
+				t.Error("expected the claim type to be checked")
+			}
+		}),
+	}
+	h.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest("GET", "/", nil))
+
+	r := WithPrincipal(httptest.NewRequest("GET", "/", nil), &Principal{ID: "bob"})
+	if p, ok := GetPrincipal(r); !ok || p.ID != "bob" {
+		t.Errorf("expected the principal of the request, got %v", p)
+	}
+	if _, ok := GetPrincipal(httptest.NewRequest("GET", "/", nil)); ok {
+		t.Error("expected no principal")
+	}
+}
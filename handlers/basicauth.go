@@ -0,0 +1,133 @@
+package handlers
+
+import (
+	"bufio"
+	"crypto/sha1"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/base64"
+	"fmt"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+
+	"golang.org/x/crypto/bcrypt"
+)
+
+// CredentialStore checks the passwords of users.
+type CredentialStore interface {
+	// Verify checks the password of the user. It should take the same time
+	// whether the user exists or not.
+	Verify(user, password string) bool
+}
+
+// Credentials is a CredentialStore of plain text passwords, by user name.
+// It also serves DigestAuth.
+type Credentials map[string]string
+
+func (c Credentials) Verify(user, password string) bool {
+	expected, ok := c[user]
+	// hashing first makes the comparison independent of the lengths
+	a, b := sha256.Sum256([]byte(password)), sha256.Sum256([]byte(expected))
+	return subtle.ConstantTimeCompare(a[:], b[:]) == 1 && ok
+}
+
+// Password returns the password of the user, see DigestStore.
+func (c Credentials) Password(user string) (string, bool) {
+	p, ok := c[user]
+	return p, ok
+}
+
+// Htpasswd is a CredentialStore loaded from an Apache htpasswd file, with
+// bcrypt ("htpasswd -B") or SHA-1 ("htpasswd -s") hashes.
+type Htpasswd map[string]string
+
+// dummyHash is verified for unknown users, so they take as long as the
+// others. It is a bcrypt hash of "dummy" with the default cost.
+var dummyHash = []byte("$2a$10$ja20xhQ5.Zn9TuancmS/8.eH4k9cTKZYoXXNWl0J6i7T2h4RCLi1e")
+
+// LoadHtpasswd reads the htpasswd file. Lines with other hash formats, such
+// as MD5 ("$apr1$") or crypt, are rejected.
+func LoadHtpasswd(path string) (Htpasswd, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	h := make(Htpasswd)
+	s := bufio.NewScanner(f)
+	for n := 1; s.Scan(); n++ {
+		line := strings.TrimSpace(s.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		user, hash, ok := strings.Cut(line, ":")
+		if !ok || !(isBcrypt(hash) || strings.HasPrefix(hash, "{SHA}")) {
+			return nil, fmt.Errorf("%s:%d: unsupported htpasswd entry", path, n)
+		}
+		h[user] = hash
+	}
+	return h, s.Err()
+}
+
+func isBcrypt(hash string) bool {
+	return strings.HasPrefix(hash, "$2y$") || strings.HasPrefix(hash, "$2a$") || strings.HasPrefix(hash, "$2b$")
+}
+
+func (h Htpasswd) Verify(user, password string) bool {
+	hash, ok := h[user]
+	switch {
+	case !ok:
+		bcrypt.CompareHashAndPassword(dummyHash, []byte(password))
+		return false
+	case isBcrypt(hash):
+		return bcrypt.CompareHashAndPassword([]byte(hash), []byte(password)) == nil
+	case strings.HasPrefix(hash, "{SHA}"):
+		sum := sha1.Sum([]byte(password))
+		expected := "{SHA}" + base64.StdEncoding.EncodeToString(sum[:])
+		return subtle.ConstantTimeCompare([]byte(hash), []byte(expected)) == 1
+	}
+	return false
+}
+
+// BasicAuth is HTTP Basic authentication (RFC 7617). The principal of the
+// authenticated requests, see GetPrincipal, has the user name as ID.
+//
+// Usage example:
+//
+//	users, err := handlers.LoadHtpasswd("/etc/app/htpasswd")
+//	...
+//	http.Handle("/admin/", handlers.BasicAuth{Realm: "admin", Store: users}.Handler(adminMux))
+type BasicAuth struct {
+	Realm string
+	Store CredentialStore
+}
+
+// Principal is a PrincipalAuthenticator checking the credentials of the
+// request.
+func (this BasicAuth) Principal(req *http.Request) (*Principal, bool) {
+	user, password, ok := req.BasicAuth()
+	if !ok || !this.Store.Verify(user, password) {
+		return nil, false
+	}
+	return &Principal{ID: user}, true
+}
+
+// Authenticate is an Authenticator checking the credentials of the request.
+func (this BasicAuth) Authenticate(req *http.Request) bool {
+	_, ok := this.Principal(req)
+	return ok
+}
+
+// Challenge answers 401 Unauthorized, with the WWW-Authenticate header
+// asking the browser for credentials. It is the Fallback of Handler.
+func (this BasicAuth) Challenge(w http.ResponseWriter, req *http.Request) {
+	w.Header().Set("WWW-Authenticate", "Basic realm="+strconv.Quote(this.Realm)+`, charset="UTF-8"`)
+	http.Error(w, http.StatusText(http.StatusUnauthorized), http.StatusUnauthorized)
+}
+
+// Handler returns an Auth handler protecting next.
+func (this BasicAuth) Handler(next http.Handler) Auth {
+	return Auth{P: this.Principal, Next: next, Fallback: http.HandlerFunc(this.Challenge)}
+}
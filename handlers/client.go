@@ -0,0 +1,291 @@
+package handlers
+
+import (
+	"compress/gzip"
+	"compress/zlib"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+var ErrBodyTooLarge = errors.New("Response body exceeds the size limit")
+
+const (
+	// DefaultMaxResponseSize is the maximum size of a decoded response body
+	// when Client.MaxBodySize is 0.
+	DefaultMaxResponseSize = 10 << 20
+	// DefaultBackoff is the delay before the first retry when
+	// Client.Backoff is 0.
+	DefaultBackoff = 100 * time.Millisecond
+	// DefaultMaxRetryDelay is the longest delay before a retry when
+	// Client.MaxRetryDelay is 0.
+	DefaultMaxRetryDelay = 10 * time.Second
+)
+
+// StatusError is returned by Client.Get for responses other than 2xx.
+type StatusError struct {
+	StatusCode int
+	Status     string
+}
+
+func (e *StatusError) Error() string {
+	return "Unexpected response status " + e.Status
+}
+
+// Client is an HTTP client for service to service requests. It decodes gzip
+// and deflate response bodies, limits their size and retries idempotent
+// requests which fail with a network error or a 502, 503 or 504 status.
+// The zero value uses http.DefaultClient and does not retry; NewClient
+// returns a Client with its own transport and sensible defaults.
+//
+// Usage example:
+//
+//	client := handlers.NewClient()
+//	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+//	defer cancel()
+//	body, err := client.Get(ctx, "https://api.example.com/items")
+type Client struct {
+	HTTPClient *http.Client
+	// MaxBodySize is the maximum size of a decoded response body.
+	// DefaultMaxResponseSize if 0. Reading more fails with ErrBodyTooLarge.
+	MaxBodySize int64
+	// Retries is the number of retries of a failed idempotent request.
+	Retries int
+	// Backoff is the delay before the first retry, doubled for every next
+	// one up to MaxRetryDelay. DefaultBackoff if 0. A Retry-After response
+	// header, in seconds or as an HTTP date, takes precedence.
+	Backoff time.Duration
+	// MaxRetryDelay is the longest delay before a retry,
+	// DefaultMaxRetryDelay if 0. A request whose Retry-After is longer, or
+	// which should wait past the deadline of its context, is not retried:
+	// the last response or error is returned.
+	MaxRetryDelay time.Duration
+}
+
+// NewClient returns a Client with a 30s timeout and 2 retries. TLS
+// certificates are verified.
+func NewClient() *Client {
+	tr := http.DefaultTransport.(*http.Transport).Clone()
+	// the Client decodes the responses itself, including deflate
+	tr.DisableCompression = true
+	return &Client{
+		HTTPClient: &http.Client{Transport: tr, Timeout: 30 * time.Second},
+		Retries:    2,
+	}
+}
+
+// Get fetches the url and returns the decoded response body. Responses
+// other than 2xx fail with a *StatusError.
+func (c *Client) Get(ctx context.Context, url string) ([]byte, error) {
+	req, err := http.NewRequestWithContext(ctx, "GET", url, nil)
+	if err != nil {
+		return nil, err
+	}
+	resp, err := c.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return nil, &StatusError{resp.StatusCode, resp.Status}
+	}
+	return io.ReadAll(resp.Body)
+}
+
+// Do sends the request, retrying it if it is idempotent, and returns the
+// response with a decoded body. Requests with a body are retried only if
+// req.GetBody is set, as done by http.NewRequest.
+func (c *Client) Do(req *http.Request) (*http.Response, error) {
+	client := c.HTTPClient
+	if client == nil {
+		client = http.DefaultClient
+	}
+	if req.Header.Get("Accept-Encoding") == "" {
+		// the request of the caller stays untouched
+		req = req.Clone(req.Context())
+		req.Header.Set("Accept-Encoding", "gzip, deflate")
+	}
+	retries := c.Retries
+	if !isIdempotent(req) {
+		retries = 0
+	}
+	backoff := c.Backoff
+	if backoff <= 0 {
+		backoff = DefaultBackoff
+	}
+	max := c.MaxRetryDelay
+	if max <= 0 {
+		max = DefaultMaxRetryDelay
+	}
+
+	for attempt := 0; ; attempt++ {
+		if attempt > 0 && req.GetBody != nil {
+			body, err := req.GetBody()
+			if err != nil {
+				return nil, err
+			}
+			req.Body = body
+		}
+		resp, err := client.Do(req)
+		retry := attempt < retries && shouldRetry(resp, err)
+		delay := backoffDelay(backoff, max, attempt)
+		if retry && resp != nil {
+			if after, ok := retryAfter(resp.Header.Get("Retry-After")); ok {
+				delay = after
+			}
+		}
+		if !retry || !mayWait(req, delay, max) {
+			if err != nil {
+				return nil, err
+			}
+			return c.decode(resp)
+		}
+		if resp != nil {
+			io.Copy(io.Discard, io.LimitReader(resp.Body, 4<<10))
+			resp.Body.Close()
+		}
+		t := time.NewTimer(delay)
+		select {
+		case <-t.C:
+		case <-req.Context().Done():
+			t.Stop()
+			return nil, req.Context().Err()
+		}
+	}
+}
+
+// backoffDelay returns the delay before the retry following the attempt:
+// backoff doubled for every attempt, up to max.
+func backoffDelay(backoff, max time.Duration, attempt int) time.Duration {
+	delay := backoff
+	for i := 0; i < attempt && delay < max; i++ {
+		delay *= 2
+	}
+	if delay > max || delay <= 0 {
+		return max
+	}
+	return delay
+}
+
+// retryAfter parses a Retry-After header, a number of seconds or an HTTP
+// date (RFC 9110, section 10.2.3).
+func retryAfter(value string) (time.Duration, bool) {
+	if value == "" {
+		return 0, false
+	}
+	if s, err := strconv.ParseInt(value, 10, 64); err == nil && s >= 0 {
+		if s > int64(math.MaxInt64/time.Second) {
+			return math.MaxInt64, true
+		}
+		return time.Duration(s) * time.Second, true
+	}
+	t, err := http.ParseTime(value)
+	if err != nil {
+		return 0, false
+	}
+	if d := time.Until(t); d > 0 {
+		return d, true
+	}
+	return 0, true
+}
+
+// mayWait checks if the request may be retried after the delay.
+func mayWait(req *http.Request, delay, max time.Duration) bool {
+	if delay > max {
+		return false
+	}
+	deadline, ok := req.Context().Deadline()
+	return !ok || time.Now().Add(delay).Before(deadline)
+}
+
+// decode replaces the body of the response by the decoded one, limited
+// to MaxBodySize.
+func (c *Client) decode(resp *http.Response) (*http.Response, error) {
+	max := c.MaxBodySize
+	if max <= 0 {
+		max = DefaultMaxResponseSize
+	}
+	var r io.Reader = resp.Body
+	switch coding := strings.ToLower(resp.Header.Get("Content-Encoding")); coding {
+	case "", "identity":
+	case "gzip", "x-gzip", "deflate":
+		var err error
+		if coding == "deflate" {
+			r, err = zlib.NewReader(resp.Body)
+		} else {
+			r, err = gzip.NewReader(resp.Body)
+		}
+		if err != nil {
+			resp.Body.Close()
+			return nil, err
+		}
+		resp.Header.Del("Content-Encoding")
+		resp.Header.Del("Content-Length")
+		resp.ContentLength = -1
+		resp.Uncompressed = true
+	default:
+		resp.Body.Close()
+		return nil, fmt.Errorf("Unsupported response Content-Encoding %s", coding)
+	}
+	resp.Body = &limitedBody{r: r, n: max, Closer: resp.Body}
+	return resp, nil
+}
+
+// isIdempotent checks if the request may be sent again.
+func isIdempotent(req *http.Request) bool {
+	if req.Body != nil && req.Body != http.NoBody && req.GetBody == nil {
+		return false
+	}
+	switch req.Method {
+	case "", "GET", "HEAD", "OPTIONS", "TRACE", "PUT", "DELETE":
+		return true
+	}
+	return req.Header.Get("Idempotency-Key") != ""
+}
+
+func shouldRetry(resp *http.Response, err error) bool {
+	if err != nil {
+		// errors of the context are final, see http.Client.Do
+		return !errors.Is(err, context.Canceled) && !errors.Is(err, context.DeadlineExceeded)
+	}
+	switch resp.StatusCode {
+	case http.StatusBadGateway, http.StatusServiceUnavailable, http.StatusGatewayTimeout:
+		return true
+	}
+	return false
+}
+
+// limitedBody fails with ErrBodyTooLarge after reading n bytes.
+type limitedBody struct {
+	r io.Reader
+	n int64
+	io.Closer
+}
+
+func (b *limitedBody) Read(p []byte) (int, error) {
+	if b.n <= 0 {
+		// more to read means the body is too large
+		var one [1]byte
+		for {
+			n, err := b.r.Read(one[:])
+			if n > 0 {
+				return 0, ErrBodyTooLarge
+			}
+			if err != nil {
+				return 0, err
+			}
+		}
+	}
+	if int64(len(p)) > b.n {
+		p = p[:b.n]
+	}
+	n, err := b.r.Read(p)
+	b.n -= int64(n)
+	return n, err
+}
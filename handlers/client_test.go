@@ -0,0 +1,119 @@
+package handlers
+
+import (
+	"compress/gzip"
+	"context"
+	"errors"
+	"math"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestClient(t *testing.T) {
+	var calls atomic.Int32
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/flaky":
+			if calls.Add(1) < 3 {
+				w.WriteHeader(http.StatusServiceUnavailable)
+				return
+			}
+			w.Write([]byte("ok"))
+		case "/gzip":
+			w.Header().Set("Content-Encoding", "gzip")
+			gz := gzip.NewWriter(w)
+			gz.Write([]byte(strings.Repeat("a", 100)))
+			gz.Close()
+		case "/missing":
+			http.NotFound(w, r)
+		case "/later":
+			calls.Add(1)
+			w.Header().Set("Retry-After", r.URL.Query().Get("after"))
+			w.WriteHeader(http.StatusServiceUnavailable)
+		case "/slow":
+			time.Sleep(time.Second)
+		}
+	}))
+	defer srv.Close()
+
+	c := NewClient()
+	c.Backoff = time.Millisecond
+	ctx := context.Background()
+
+	if body, err := c.Get(ctx, srv.URL+"/flaky"); err != nil || string(body) != "ok" || calls.Load() != 3 {
+		t.Errorf("unexpected response %q %v after %d calls", body, err, calls.Load())
+	}
+	if body, err := c.Get(ctx, srv.URL+"/gzip"); err != nil || string(body) != strings.Repeat("a", 100) {
+		t.Errorf("unexpected gzip response %q %v", body, err)
+	}
+	c.MaxBodySize = 99
+	if _, err := c.Get(ctx, srv.URL+"/gzip"); err != ErrBodyTooLarge {
+		t.Errorf("expected ErrBodyTooLarge, got %v", err)
+	}
+	var se *StatusError
+	if _, err := c.Get(ctx, srv.URL+"/missing"); !errors.As(err, &se) || se.StatusCode != 404 {
+		t.Errorf("expected a 404 StatusError, got %v", err)
+	}
+
+	// a retry too far away is not waited for
+	start := time.Now()
+	calls.Store(0)
+	if _, err := c.Get(ctx, srv.URL+"/later?after=86400"); !errors.As(err, &se) || se.StatusCode != 503 || calls.Load() != 1 {
+		t.Errorf("expected a 503 StatusError at once, got %v after %d calls", err, calls.Load())
+	}
+	ctx, cancel := context.WithTimeout(ctx, 500*time.Millisecond)
+	defer cancel()
+	if _, err := c.Get(ctx, srv.URL+"/later?after=1"); !errors.As(err, &se) || se.StatusCode != 503 || calls.Load() != 2 {
+		t.Errorf("expected a 503 StatusError before the deadline, got %v after %d calls", err, calls.Load())
+	}
+	calls.Store(0)
+	later := url.QueryEscape(time.Now().Add(24 * time.Hour).UTC().Format(http.TimeFormat))
+	if _, err := c.Get(context.Background(), srv.URL+"/later?after="+later); !errors.As(err, &se) || calls.Load() != 1 {
+		t.Errorf("expected a 503 StatusError at once for an HTTP date, got %v after %d calls", err, calls.Load())
+	}
+	if elapsed := time.Since(start); elapsed > 200*time.Millisecond {
+		t.Errorf("the retries were waited for, %v", elapsed)
+	}
+	calls.Store(0)
+	past := url.QueryEscape(time.Now().Add(-time.Hour).UTC().Format(http.TimeFormat))
+	if _, err := c.Get(context.Background(), srv.URL+"/later?after="+past); !errors.As(err, &se) || calls.Load() != 3 {
+		t.Errorf("expected the retries of a past HTTP date, got %v after %d calls", err, calls.Load())
+	}
+
+	ctx, cancel = context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	if _, err := c.Get(ctx, srv.URL+"/slow"); !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("expected the deadline to be exceeded, got %v", err)
+	}
+}
+
+func TestBackoffDelay(t *testing.T) {
+	for _, c := range []struct {
+		backoff, max time.Duration
+		attempt      int
+		want         time.Duration
+	}{
+		{time.Second, time.Minute, 0, time.Second},
+		{time.Second, time.Minute, 3, 8 * time.Second},
+		{time.Second, time.Minute, 10, time.Minute},
+		{time.Second, time.Minute, 1000, time.Minute},
+		{time.Hour, math.MaxInt64, 1000, math.MaxInt64},
+	} {
+		if got := backoffDelay(c.backoff, c.max, c.attempt); got != c.want {
+			t.Errorf("%v up to %v, attempt %d: got %v, want %v", c.backoff, c.max, c.attempt, got, c.want)
+		}
+	}
+}
+
+func TestClientVerifiesTLS(t *testing.T) {
+	srv := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	defer srv.Close()
+	if _, err := NewClient().Get(context.Background(), srv.URL); err == nil {
+		t.Error("expected a certificate error")
+	}
+}
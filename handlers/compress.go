@@ -0,0 +1,101 @@
+package handlers
+
+import (
+	"compress/gzip"
+	"compress/zlib"
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+)
+
+// Encoder is a content coding of responses, used by GzipConfig.
+type Encoder interface {
+	// Coding returns the name of the coding in Accept-Encoding and
+	// Content-Encoding, eg. "gzip".
+	Coding() string
+	// NewWriter returns a writer encoding to w. level is in the range of
+	// compress/flate, where -1 is the default compression.
+	NewWriter(w io.Writer, level int) (EncoderWriter, error)
+}
+
+// EncoderWriter is a writer of an Encoder. Writers are pooled and reused
+// for other responses after Reset.
+type EncoderWriter interface {
+	io.WriteCloser
+	Flush() error
+	Reset(w io.Writer)
+}
+
+var (
+	// GzipEncoder is the gzip coding (RFC 1952).
+	GzipEncoder Encoder = gzipEncoder{}
+	// DeflateEncoder is the deflate coding, which is the zlib format
+	// (RFC 1950), not raw deflate.
+	DeflateEncoder Encoder = deflateEncoder{}
+)
+
+type gzipEncoder struct{}
+
+func (gzipEncoder) Coding() string { return "gzip" }
+
+func (gzipEncoder) NewWriter(w io.Writer, level int) (EncoderWriter, error) {
+	return gzip.NewWriterLevel(w, level)
+}
+
+type deflateEncoder struct{}
+
+func (deflateEncoder) Coding() string { return "deflate" }
+
+func (deflateEncoder) NewWriter(w io.Writer, level int) (EncoderWriter, error) {
+	return zlib.NewWriterLevel(w, level)
+}
+
+// negotiateEncoding returns the index of the encoder with the highest
+// quality value in the Accept-Encoding header of the request, or -1 if none
+// is acceptable. On a tie the first one of encoders wins.
+func negotiateEncoding(r *http.Request, encoders []Encoder) int {
+	accepted := parseAcceptEncoding(r.Header.Values("Accept-Encoding"))
+	var (
+		best  = -1
+		bestQ float64
+	)
+	for i, e := range encoders {
+		q, ok := accepted[e.Coding()]
+		if !ok {
+			q = accepted["*"]
+		}
+		if q > bestQ {
+			best, bestQ = i, q
+		}
+	}
+	return best
+}
+
+// parseAcceptEncoding returns the quality value of every coding of the
+// Accept-Encoding header, eg. "gzip;q=1.0, identity; q=0.5, *;q=0".
+// Invalid quality values are ignored, the coding is then not acceptable.
+func parseAcceptEncoding(values []string) map[string]float64 {
+	accepted := make(map[string]float64)
+	for _, v := range values {
+		for _, part := range strings.Split(v, ",") {
+			coding, params, _ := strings.Cut(part, ";")
+			coding = strings.ToLower(strings.TrimSpace(coding))
+			if coding == "" {
+				continue
+			}
+			if coding == "x-gzip" {
+				coding = "gzip"
+			}
+			q := 1.0
+			if name, value, ok := strings.Cut(params, "="); ok && strings.TrimSpace(strings.ToLower(name)) == "q" {
+				var err error
+				if q, err = strconv.ParseFloat(strings.TrimSpace(value), 64); err != nil || q < 0 || q > 1 {
+					q = 0
+				}
+			}
+			accepted[coding] = q
+		}
+	}
+	return accepted
+}
@@ -0,0 +1,91 @@
+package handlers
+
+import (
+	"compress/zlib"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestNegotiateEncoding(t *testing.T) {
+	encoders := []Encoder{GzipEncoder, DeflateEncoder}
+	for header, want := range map[string]string{
+		"":                          "",
+		"gzip":                      "gzip",
+		"x-gzip":                    "gzip",
+		"deflate, gzip":             "gzip",
+		"deflate":                   "deflate",
+		"gzip;q=0, deflate":         "deflate",
+		"gzip;q=0.5, deflate;q=0.8": "deflate",
+		"GZIP; Q=0.8, deflate;q=.5": "gzip",
+		"*":                         "gzip",
+		"*;q=0.1, gzip;q=0":         "deflate",
+		"br":                        "",
+		"gzip;q=x":                  "",
+		"identity":                  "",
+	} {
+		r := httptest.NewRequest("GET", "/", nil)
+		r.Header.Set("Accept-Encoding", header)
+		got := ""
+		if i := negotiateEncoding(r, encoders); i >= 0 {
+			got = encoders[i].Coding()
+		}
+		if got != want {
+			t.Errorf("%q: expected %q, got %q", header, want, got)
+		}
+	}
+}
+
+func TestCompressDeflate(t *testing.T) {
+	body := strings.Repeat("hello ", 1000)
+	h := Compress(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		io.WriteString(w, body)
+	}))
+	for i := 0; i < 2; i++ { // the second time with a pooled writer
+		req := httptest.NewRequest("GET", "/", nil)
+		req.Header.Set("Accept-Encoding", "gzip;q=0.5, deflate")
+		w := httptest.NewRecorder()
+		h.ServeHTTP(w, req)
+		if ce := w.Header().Get("Content-Encoding"); ce != "deflate" {
+			t.Fatalf("expected deflate, got %q", ce)
+		}
+		zr, err := zlib.NewReader(w.Body)
+		if err != nil {
+			t.Fatal(err)
+		}
+		got, err := io.ReadAll(zr)
+		if err != nil || string(got) != body {
+			t.Errorf("unexpected body of %d bytes: %v", len(got), err)
+		}
+	}
+}
+
+// levelEncoder is not comparable, like encoders holding their options in
+// a slice or a map.
+type levelEncoder struct {
+	Encoder
+	levels []int
+}
+
+func TestCompressNotComparable(t *testing.T) {
+	body := strings.Repeat("hello ", 1000)
+	h := GzipConfig{Encoders: []Encoder{levelEncoder{DeflateEncoder, []int{1}}}}.Handler(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		io.WriteString(w, body)
+	}))
+	req := httptest.NewRequest("GET", "/", nil)
+	req.Header.Set("Accept-Encoding", "deflate")
+	w := httptest.NewRecorder()
+	h.ServeHTTP(w, req)
+	if ce := w.Header().Get("Content-Encoding"); ce != "deflate" {
+		t.Fatalf("expected deflate, got %q", ce)
+	}
+	zr, err := zlib.NewReader(w.Body)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got, err := io.ReadAll(zr); err != nil || string(got) != body {
+		t.Errorf("unexpected body of %d bytes: %v", len(got), err)
+	}
+}
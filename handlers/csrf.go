@@ -0,0 +1,282 @@
+package handlers
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/base64"
+	"errors"
+	"html/template"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+)
+
+var (
+	ErrCSRFCrossSite = errors.New("Cross-site request")
+	ErrCSRFOrigin    = errors.New("Untrusted request origin")
+	ErrCSRFToken     = errors.New("Missing or invalid CSRF token")
+)
+
+const (
+	DefaultCSRFCookieName = "_csrf"
+	DefaultCSRFFieldName  = "csrf_token"
+	DefaultCSRFHeaderName = "X-CSRF-Token"
+)
+
+// CSRFTokenStore keeps the CSRF tokens in the server side sessions, for
+// the synchronizer token pattern. sessions.Manager is a CSRFTokenStore.
+type CSRFTokenStore interface {
+	// CSRFToken returns the token of the session of the request, creating
+	// it if needed.
+	CSRFToken(req *http.Request) string
+}
+
+// CSRF protects Next against cross-site request forgery. The requests with
+// an unsafe method (other than GET, HEAD, OPTIONS and TRACE) are rejected
+// unless:
+//   - their Sec-Fetch-Site header, if any, is "same-origin" or "none",
+//   - their Origin header, or else their Referer, if any, is the request host
+//     or one of TrustedOrigins,
+//   - and they carry the token of the client, in the HeaderName header or in
+//     the FieldName form field.
+//
+// The tokens are kept in the server side sessions if Store is set, which
+// then gives a session to every client, and in a cookie otherwise
+// (double-submit cookie). The cookie is signed with Key, so a value planted
+// by a sibling subdomain is rejected; the cookie of another client may still
+// be planted, which Store prevents. The handlers get the token with
+// CSRFToken, and the templates with the functions of CSRFFuncs.
+//
+// Usage example, with the templates of a contentnegotiator.TRenderer:
+//
+//	t := template.Must(template.New("").Funcs(handlers.CSRFFuncs(nil)).ParseGlob("templates/*.html"))
+//	pages := contentnegotiator.TRenderer{Log: logger, T: t, H: pageHandler}.WithFuncs(handlers.CSRFFuncs)
+//	http.Handle("/", handlers.CSRF{Next: pages, Key: csrfKey, Secure: true, ExemptPaths: []string{"/api/"}})
+//
+// and in the templates:
+//
+//	<form method="post">{{csrfField}} ... </form>
+type CSRF struct {
+	Next  http.Handler
+	Store CSRFTokenStore
+
+	// CookieName is the cookie of the double-submit tokens,
+	// DefaultCSRFCookieName if empty.
+	CookieName string
+	// Key signs the cookie. If nil a random key is used, which is lost on
+	// restart and differs between processes: set it when several instances
+	// serve the site.
+	Key []byte
+	// Secure sends the cookie over https only. Set it in production.
+	Secure bool
+	// FieldName is DefaultCSRFFieldName if empty.
+	FieldName string
+	// HeaderName is DefaultCSRFHeaderName if empty.
+	HeaderName string
+
+	// TrustedOrigins are other origins allowed to send requests, eg.
+	// "https://admin.example.com".
+	TrustedOrigins []string
+	// ExemptPaths are path prefixes not checked, eg. APIs authenticated by
+	// a header rather than a cookie.
+	ExemptPaths []string
+	// Fallback handles the rejected requests. If nil they are answered
+	// with 403 Forbidden.
+	Fallback http.Handler
+}
+
+type csrfKey struct{}
+
+// csrfState is the token of a request, and the form field carrying it.
+type csrfState struct {
+	token string
+	field string
+}
+
+func (this CSRF) ServeHTTP(w http.ResponseWriter, req *http.Request) {
+	for _, p := range this.ExemptPaths {
+		if strings.HasPrefix(req.URL.Path, p) {
+			this.Next.ServeHTTP(w, req)
+			return
+		}
+	}
+
+	state := &csrfState{field: this.FieldName}
+	if state.field == "" {
+		state.field = DefaultCSRFFieldName
+	}
+	// the token is taken before Next writes the response headers, which
+	// carry its cookie or the session one
+	if this.Store != nil {
+		state.token = this.Store.CSRFToken(req)
+	} else if c, err := req.Cookie(this.cookieName()); err == nil && this.verifyCookie(c.Value) {
+		state.token = c.Value[:43]
+	} else {
+		state.token = NewCSRFToken()
+		http.SetCookie(w, &http.Cookie{
+			Name:     this.cookieName(),
+			Value:    state.token + "." + this.sign(state.token),
+			Path:     "/",
+			Secure:   this.Secure,
+			HttpOnly: true,
+			SameSite: http.SameSiteLaxMode,
+		})
+	}
+	req = req.WithContext(context.WithValue(req.Context(), csrfKey{}, state))
+
+	switch req.Method {
+	case "GET", "HEAD", "OPTIONS", "TRACE":
+	default:
+		if err := this.check(req, state); err != nil {
+			if this.Fallback != nil {
+				this.Fallback.ServeHTTP(w, req)
+			} else {
+				http.Error(w, "Forbidden: "+err.Error(), http.StatusForbidden)
+			}
+			return
+		}
+	}
+	this.Next.ServeHTTP(w, req)
+}
+
+func (this CSRF) check(req *http.Request, state *csrfState) error {
+	switch req.Header.Get("Sec-Fetch-Site") {
+	case "", "same-origin", "none":
+	default:
+		// same-site requests come from other subdomains
+		if !this.trustedOrigin(req.Header.Get("Origin")) {
+			return ErrCSRFCrossSite
+		}
+	}
+
+	if origin := req.Header.Get("Origin"); origin != "" && origin != "null" {
+		if !this.sameHost(req, origin) && !this.trustedOrigin(origin) {
+			return ErrCSRFOrigin
+		}
+	} else if referer := req.Header.Get("Referer"); referer != "" {
+		u, err := url.Parse(referer)
+		if err != nil || !(this.sameHost(req, u.Scheme+"://"+u.Host) || this.trustedOrigin(u.Scheme+"://"+u.Host)) {
+			return ErrCSRFOrigin
+		}
+	}
+
+	header := this.HeaderName
+	if header == "" {
+		header = DefaultCSRFHeaderName
+	}
+	token := req.Header.Get(header)
+	if token == "" {
+		token = req.PostFormValue(state.field)
+	}
+	expected := state.token
+	if token == "" || expected == "" || subtle.ConstantTimeCompare([]byte(token), []byte(expected)) != 1 {
+		return ErrCSRFToken
+	}
+	return nil
+}
+
+func (this CSRF) sameHost(req *http.Request, origin string) bool {
+	u, err := url.Parse(origin)
+	return err == nil && strings.EqualFold(u.Host, req.Host)
+}
+
+func (this CSRF) trustedOrigin(origin string) bool {
+	for _, o := range this.TrustedOrigins {
+		if strings.EqualFold(o, origin) {
+			return true
+		}
+	}
+	return false
+}
+
+func (this CSRF) cookieName() string {
+	if this.CookieName == "" {
+		return DefaultCSRFCookieName
+	}
+	return this.CookieName
+}
+
+// csrfSigningKey is the key of the cookies of CSRF without Key.
+var csrfSigningKey struct {
+	once sync.Once
+	key  []byte
+}
+
+// sign returns the signature of the token, which the cookie carries after
+// a dot.
+func (this CSRF) sign(token string) string {
+	key := this.Key
+	if key == nil {
+		csrfSigningKey.once.Do(func() {
+			csrfSigningKey.key = make([]byte, 32)
+			if _, err := rand.Read(csrfSigningKey.key); err != nil {
+				panic(err)
+			}
+		})
+		key = csrfSigningKey.key
+	}
+	mac := hmac.New(sha256.New, key)
+	mac.Write([]byte(token))
+	return base64.RawURLEncoding.EncodeToString(mac.Sum(nil))
+}
+
+// verifyCookie checks the signature of the cookie value.
+func (this CSRF) verifyCookie(value string) bool {
+	token, sig, ok := strings.Cut(value, ".")
+	return ok && len(token) == 43 && hmac.Equal([]byte(sig), []byte(this.sign(token)))
+}
+
+// NewCSRFToken returns a random token of 43 characters, for the
+// CSRFTokenStore implementations.
+func NewCSRFToken() string {
+	b := make([]byte, 32)
+	if _, err := rand.Read(b); err != nil {
+		panic(err)
+	}
+	return base64.RawURLEncoding.EncodeToString(b)
+}
+
+// CSRFToken returns the token of the request, to send back in the forms or
+// the headers of the unsafe requests. It is empty if the request did not go
+// through CSRF.
+func CSRFToken(req *http.Request) string {
+	if state, ok := req.Context().Value(csrfKey{}).(*csrfState); ok {
+		return state.token
+	}
+	return ""
+}
+
+// CSRFFuncs returns the template functions of the CSRF token of the
+// request:
+//
+//	{{csrfToken}}   the token, eg. for a meta tag read by scripts
+//	{{csrfField}}   a hidden form field holding the token
+//
+// The templates are parsed with CSRFFuncs(nil), where the functions return
+// empty strings, and executed with the functions of the request, see
+// contentnegotiator.TRenderer.WithFuncs.
+func CSRFFuncs(req *http.Request) template.FuncMap {
+	var state *csrfState
+	if req != nil {
+		state, _ = req.Context().Value(csrfKey{}).(*csrfState)
+	}
+	return template.FuncMap{
+		"csrfToken": func() string {
+			if state == nil {
+				return ""
+			}
+			return state.token
+		},
+		"csrfField": func() template.HTML {
+			if state == nil {
+				return ""
+			}
+			return template.HTML(`<input type="hidden" name="` + template.HTMLEscapeString(state.field) +
+				`" value="` + template.HTMLEscapeString(state.token) + `">`)
+		},
+	}
+}
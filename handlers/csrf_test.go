@@ -0,0 +1,121 @@
+package handlers
+
+import (
+	"html/template"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+
+	"github.com/scale-it/go-web/contentnegotiator"
+)
+
+func TestCSRF(t *testing.T) {
+	h := CSRF{
+		Next:           http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) { w.Write([]byte(CSRFToken(r))) }),
+		TrustedOrigins: []string{"https://admin.example.com"},
+		ExemptPaths:    []string{"/api/"},
+	}
+	w := httptest.NewRecorder()
+	h.ServeHTTP(w, httptest.NewRequest("GET", "https://example.com/form", nil))
+	cookies := w.Result().Cookies()
+	token := w.Body.String()
+	if w.Code != 200 || len(cookies) != 1 || !strings.HasPrefix(cookies[0].Value, token+".") || len(token) != 43 {
+		t.Fatalf("GET: got %d %q, cookies %v", w.Code, token, cookies)
+	}
+
+	for _, c := range []struct {
+		name    string
+		path    string
+		form    string
+		headers map[string]string
+		err     error
+	}{
+		{"form token", "/form", "csrf_token=" + token, nil, nil},
+		{"header token", "/form", "", map[string]string{"X-CSRF-Token": token}, nil},
+		{"no token", "/form", "", nil, ErrCSRFToken},
+		{"wrong token", "/form", "csrf_token=" + NewCSRFToken(), nil, ErrCSRFToken},
+		{"exempt", "/api/items", "", nil, nil},
+		{"same origin", "/form", "csrf_token=" + token, map[string]string{"Origin": "https://example.com", "Sec-Fetch-Site": "same-origin"}, nil},
+		{"trusted origin", "/form", "csrf_token=" + token, map[string]string{"Origin": "https://admin.example.com", "Sec-Fetch-Site": "same-site"}, nil},
+		{"cross origin", "/form", "csrf_token=" + token, map[string]string{"Origin": "https://evil.com"}, ErrCSRFOrigin},
+		{"cross referer", "/form", "csrf_token=" + token, map[string]string{"Referer": "https://evil.com/page"}, ErrCSRFOrigin},
+		{"cross site", "/form", "csrf_token=" + token, map[string]string{"Sec-Fetch-Site": "cross-site"}, ErrCSRFCrossSite},
+	} {
+		req := httptest.NewRequest("POST", "https://example.com"+c.path, strings.NewReader(c.form))
+		req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+		req.AddCookie(cookies[0])
+		for k, v := range c.headers {
+			req.Header.Set(k, v)
+		}
+		w := httptest.NewRecorder()
+		h.ServeHTTP(w, req)
+		if c.err == nil && w.Code != 200 || c.err != nil && (w.Code != 403 || !strings.Contains(w.Body.String(), c.err.Error())) {
+			t.Errorf("%s: got %d %q", c.name, w.Code, w.Body)
+		}
+	}
+}
+
+func TestCSRFPlantedCookie(t *testing.T) {
+	h := CSRF{Key: []byte("secret"), Next: http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {})}
+	planted := NewCSRFToken()
+	other := CSRF{Key: []byte("other")}
+	for _, cookie := range []string{planted, planted + ".", planted + "." + other.sign(planted)} {
+		req := httptest.NewRequest("POST", "https://example.com/form", nil)
+		req.AddCookie(&http.Cookie{Name: DefaultCSRFCookieName, Value: cookie})
+		req.Header.Set(DefaultCSRFHeaderName, planted)
+		w := httptest.NewRecorder()
+		h.ServeHTTP(w, req)
+		if w.Code != 403 {
+			t.Errorf("%s: got %d", cookie, w.Code)
+		}
+	}
+
+	req := httptest.NewRequest("POST", "https://example.com/form", nil)
+	req.AddCookie(&http.Cookie{Name: DefaultCSRFCookieName, Value: planted + "." + h.sign(planted)})
+	req.Header.Set(DefaultCSRFHeaderName, planted)
+	w := httptest.NewRecorder()
+	h.ServeHTTP(w, req)
+	if w.Code != 200 {
+		t.Errorf("signed cookie: got %d", w.Code)
+	}
+}
+
+type testTokenStore map[string]string
+
+func (s testTokenStore) CSRFToken(req *http.Request) string {
+	c, _ := req.Cookie("sid")
+	return s[c.Value]
+}
+
+func TestCSRFStore(t *testing.T) {
+	h := CSRF{Store: testTokenStore{"alice": "alice-token"}, Next: http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {})}
+	for token, status := range map[string]int{"alice-token": 200, "other": 403} {
+		req := httptest.NewRequest("POST", "/", strings.NewReader(url.Values{"csrf_token": {token}}.Encode()))
+		req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+		req.AddCookie(&http.Cookie{Name: "sid", Value: "alice"})
+		w := httptest.NewRecorder()
+		h.ServeHTTP(w, req)
+		if w.Code != status || len(w.Result().Cookies()) != 0 {
+			t.Errorf("%s: got %d, cookies %v", token, w.Code, w.Result().Cookies())
+		}
+	}
+}
+
+func TestCSRFFuncs(t *testing.T) {
+	tmpl := template.Must(template.New("form").Funcs(CSRFFuncs(nil)).Parse(`<form>{{csrfField}}</form><meta content="{{csrfToken}}">`))
+	pages := contentnegotiator.TRenderer{T: tmpl, H: func(w http.ResponseWriter, r *http.Request) (string, interface{}, int) {
+		return "form", nil, 200
+	}}.WithFuncs(CSRFFuncs)
+	h := CSRF{FieldName: "_token", Next: pages}
+	for i := 0; i < 2; i++ { // the second time with a reused template
+		w := httptest.NewRecorder()
+		h.ServeHTTP(w, httptest.NewRequest("GET", "/", nil))
+		token, _, _ := strings.Cut(w.Result().Cookies()[0].Value, ".")
+		want := `<form><input type="hidden" name="_token" value="` + token + `"></form><meta content="` + token + `">`
+		if w.Body.String() != want {
+			t.Errorf("got %s, want %s", w.Body, want)
+		}
+	}
+}
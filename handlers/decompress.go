@@ -0,0 +1,95 @@
+package handlers
+
+import (
+	"compress/gzip"
+	"compress/zlib"
+	"io"
+	"net/http"
+	"strings"
+)
+
+// DefaultMaxDecompressedSize is the maximum size of a decompressed request
+// body when Decompress.MaxSize is 0.
+const DefaultMaxDecompressedSize = 10 << 20
+
+// maxCodings is the number of stacked content codings Decompress accepts,
+// since every one allocates a decoder before MaxSize applies.
+const maxCodings = 2
+
+// decoders are the content codings of request bodies supported by Decompress.
+var decoders = map[string]func(r io.Reader) (io.ReadCloser, error){
+	"gzip":    func(r io.Reader) (io.ReadCloser, error) { return gzip.NewReader(r) },
+	"x-gzip":  func(r io.Reader) (io.ReadCloser, error) { return gzip.NewReader(r) },
+	"deflate": zlib.NewReader,
+}
+
+// Decompress decodes the request bodies compressed with gzip or deflate
+// (Content-Encoding), so Next reads a plain body. The Content-Encoding and
+// Content-Length headers are removed.
+//
+// Requests with other codings, or with more than two stacked codings, are
+// answered with 415 Unsupported Media Type, and corrupted bodies with 400
+// Bad Request. Reading more than MaxSize decompressed bytes fails with an
+// *http.MaxBytesError, which protects against zip bombs.
+type Decompress struct {
+	Next http.Handler
+	// MaxSize is the maximum size of the decompressed body,
+	// DefaultMaxDecompressedSize if 0.
+	MaxSize int64
+}
+
+func (this Decompress) ServeHTTP(w http.ResponseWriter, req *http.Request) {
+	var codings []string
+	for _, v := range req.Header.Values("Content-Encoding") {
+		for _, c := range strings.Split(v, ",") {
+			if c = strings.ToLower(strings.TrimSpace(c)); c != "" && c != "identity" {
+				codings = append(codings, c)
+			}
+		}
+	}
+	if len(codings) == 0 || req.Body == nil || req.Body == http.NoBody {
+		this.Next.ServeHTTP(w, req)
+		return
+	}
+	if len(codings) > maxCodings {
+		http.Error(w, "Too many stacked Content-Encodings", http.StatusUnsupportedMediaType)
+		return
+	}
+	for _, c := range codings {
+		if decoders[c] == nil {
+			w.Header().Set("Accept-Encoding", "gzip, deflate")
+			http.Error(w, "Unsupported Content-Encoding "+c, http.StatusUnsupportedMediaType)
+			return
+		}
+	}
+
+	body := req.Body
+	// the codings are listed in the order they were applied
+	var r io.Reader = body
+	for i := len(codings) - 1; i >= 0; i-- {
+		dec, err := decoders[codings[i]](r)
+		if err != nil {
+			http.Error(w, "Invalid "+codings[i]+" request body", http.StatusBadRequest)
+			return
+		}
+		defer dec.Close()
+		r = dec
+	}
+	max := this.MaxSize
+	if max <= 0 {
+		max = DefaultMaxDecompressedSize
+	}
+
+	req = req.Clone(req.Context())
+	req.Body = http.MaxBytesReader(w, readCloser{r, body}, max)
+	req.ContentLength = -1
+	req.Header.Del("Content-Encoding")
+	req.Header.Del("Content-Length")
+	this.Next.ServeHTTP(w, req)
+}
+
+// readCloser reads the decoded body and closes the original one.
+type readCloser struct {
+	io.Reader
+	io.Closer
+}
@@ -0,0 +1,78 @@
+package handlers
+
+import (
+	"bytes"
+	"compress/gzip"
+	"compress/zlib"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestDecompress(t *testing.T) {
+	var gz, zl bytes.Buffer
+	w := gzip.NewWriter(&gz)
+	w.Write([]byte(`{"hello": "world"}`))
+	w.Close()
+	z := zlib.NewWriter(&zl)
+	z.Write([]byte(`{"hello": "world"}`))
+	z.Close()
+	var stacked bytes.Buffer
+	w = gzip.NewWriter(&stacked)
+	w.Write(zl.Bytes())
+	w.Close()
+
+	h := Decompress{Next: http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if ce := r.Header.Get("Content-Encoding"); ce != "" {
+			t.Errorf("unexpected Content-Encoding %q", ce)
+		}
+		body, err := io.ReadAll(r.Body)
+		var maxErr *http.MaxBytesError
+		if errors.As(err, &maxErr) {
+			http.Error(w, err.Error(), http.StatusRequestEntityTooLarge)
+			return
+		}
+		w.Write(body)
+	}), MaxSize: 20}
+
+	for _, c := range []struct {
+		coding string
+		body   []byte
+		status int
+	}{
+		{"", []byte("plain"), 200},
+		{"gzip", gz.Bytes(), 200},
+		{"deflate", zl.Bytes(), 200},
+		{"deflate, gzip", stacked.Bytes(), 200},
+		{"br", gz.Bytes(), http.StatusUnsupportedMediaType},
+		{"gzip, gzip, gzip", gz.Bytes(), http.StatusUnsupportedMediaType},
+		{"gzip", []byte("not gzip"), http.StatusBadRequest},
+	} {
+		req := httptest.NewRequest("POST", "/", bytes.NewReader(c.body))
+		req.Header.Set("Content-Encoding", c.coding)
+		rec := httptest.NewRecorder()
+		h.ServeHTTP(rec, req)
+		if rec.Code != c.status {
+			t.Errorf("%q: expected status %d, got %d", c.coding, c.status, rec.Code)
+		}
+		if c.status == 200 && c.coding != "" && rec.Body.String() != `{"hello": "world"}` {
+			t.Errorf("%q: unexpected body %q", c.coding, rec.Body)
+		}
+	}
+
+	// a zip bomb
+	var bomb bytes.Buffer
+	w = gzip.NewWriter(&bomb)
+	w.Write([]byte(strings.Repeat("0", 1000)))
+	w.Close()
+	req := httptest.NewRequest("POST", "/", &bomb)
+	req.Header.Set("Content-Encoding", "gzip")
+	rec := httptest.NewRecorder()
+	h.ServeHTTP(rec, req)
+	if rec.Code != http.StatusRequestEntityTooLarge {
+		t.Errorf("expected status 413, got %d", rec.Code)
+	}
+}
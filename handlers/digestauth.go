@@ -0,0 +1,197 @@
+package handlers
+
+import (
+	"crypto/hmac"
+	"crypto/md5"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/base64"
+	"encoding/binary"
+	"encoding/hex"
+	"hash"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// DefaultNonceTTL is the validity of the nonces of DigestAuth when
+// DigestAuth.NonceTTL is 0.
+const DefaultNonceTTL = 5 * time.Minute
+
+// DigestStore returns the plain text passwords of users, which Digest
+// authentication needs. Credentials is a DigestStore.
+type DigestStore interface {
+	Password(user string) (string, bool)
+}
+
+// DigestAuth is HTTP Digest authentication (RFC 7616) with the "auth"
+// quality of protection, and SHA-256 or MD5 for older clients. The principal
+// of the authenticated requests, see GetPrincipal, has the user name as ID.
+//
+// The nonces are signed timestamps, so no state is kept; they can be
+// replayed within NonceTTL. Use it over https, like BasicAuth. A DigestAuth
+// must be created with NewDigestAuth.
+type DigestAuth struct {
+	Realm string
+	Store DigestStore
+	// NonceTTL is the validity of a nonce, DefaultNonceTTL if 0. The browser
+	// then retries with a new one, without asking the user.
+	NonceTTL time.Duration
+
+	key []byte
+}
+
+// NewDigestAuth returns a DigestAuth signing its nonces with a random key.
+func NewDigestAuth(realm string, store DigestStore) *DigestAuth {
+	key := make([]byte, 32)
+	if _, err := rand.Read(key); err != nil {
+		panic(err)
+	}
+	return &DigestAuth{Realm: realm, Store: store, key: key}
+}
+
+// Principal is a PrincipalAuthenticator checking the credentials of the
+// request.
+func (this *DigestAuth) Principal(req *http.Request) (*Principal, bool) {
+	p, ok := this.credentials(req)
+	if !ok || !this.validNonce(p["nonce"]) {
+		return nil, false
+	}
+	password, ok := this.Store.Password(p["username"])
+	h := digestHash(p["algorithm"])
+	ha1 := hexHash(h, p["username"]+":"+this.Realm+":"+password)
+	ha2 := hexHash(h, req.Method+":"+p["uri"])
+	expected := hexHash(h, ha1+":"+p["nonce"]+":"+p["nc"]+":"+p["cnonce"]+":"+p["qop"]+":"+ha2)
+	if subtle.ConstantTimeCompare([]byte(expected), []byte(p["response"])) != 1 || !ok {
+		return nil, false
+	}
+	return &Principal{ID: p["username"]}, true
+}
+
+// Authenticate is an Authenticator checking the credentials of the request.
+func (this *DigestAuth) Authenticate(req *http.Request) bool {
+	_, ok := this.Principal(req)
+	return ok
+}
+
+// Challenge answers 401 Unauthorized, with WWW-Authenticate headers asking
+// the browser for credentials, or to retry with a new nonce (stale=true).
+// It is the Fallback of Handler.
+func (this *DigestAuth) Challenge(w http.ResponseWriter, req *http.Request) {
+	nonce := this.nonce(time.Now())
+	stale := ""
+	if p, ok := this.credentials(req); ok && this.signedNonce(p["nonce"]) && !this.validNonce(p["nonce"]) {
+		stale = ", stale=true"
+	}
+	for _, algorithm := range []string{"SHA-256", "MD5"} {
+		w.Header().Add("WWW-Authenticate", "Digest realm="+strconv.Quote(this.Realm)+
+			`, qop="auth", algorithm=`+algorithm+`, nonce="`+nonce+`"`+stale)
+	}
+	http.Error(w, http.StatusText(http.StatusUnauthorized), http.StatusUnauthorized)
+}
+
+// Handler returns an Auth handler protecting next.
+func (this *DigestAuth) Handler(next http.Handler) Auth {
+	return Auth{P: this.Principal, Next: next, Fallback: http.HandlerFunc(this.Challenge)}
+}
+
+// credentials returns the parameters of the Authorization header, if they
+// are complete and match the request.
+func (this *DigestAuth) credentials(req *http.Request) (map[string]string, bool) {
+	scheme, params, _ := strings.Cut(req.Header.Get("Authorization"), " ")
+	if !strings.EqualFold(scheme, "Digest") {
+		return nil, false
+	}
+	p := parseAuthParams(params)
+	for _, name := range []string{"username", "nonce", "uri", "response", "nc", "cnonce"} {
+		if p[name] == "" {
+			return nil, false
+		}
+	}
+	if p["realm"] != this.Realm || p["qop"] != "auth" || p["uri"] != req.RequestURI || digestHash(p["algorithm"]) == nil {
+		return nil, false
+	}
+	return p, true
+}
+
+// nonce returns a nonce made of the time and its signature.
+func (this *DigestAuth) nonce(t time.Time) string {
+	b := binary.BigEndian.AppendUint64(nil, uint64(t.Unix()))
+	mac := hmac.New(sha256.New, this.key)
+	mac.Write(b)
+	return base64.RawURLEncoding.EncodeToString(mac.Sum(b))
+}
+
+// signedNonce checks the signature of the nonce.
+func (this *DigestAuth) signedNonce(nonce string) bool {
+	b, err := base64.RawURLEncoding.DecodeString(nonce)
+	if err != nil || len(b) != 8+sha256.Size {
+		return false
+	}
+	mac := hmac.New(sha256.New, this.key)
+	mac.Write(b[:8])
+	return hmac.Equal(mac.Sum(nil), b[8:])
+}
+
+// validNonce checks the signature and the age of the nonce.
+func (this *DigestAuth) validNonce(nonce string) bool {
+	if !this.signedNonce(nonce) {
+		return false
+	}
+	b, _ := base64.RawURLEncoding.DecodeString(nonce)
+	ttl := this.NonceTTL
+	if ttl <= 0 {
+		ttl = DefaultNonceTTL
+	}
+	return time.Since(time.Unix(int64(binary.BigEndian.Uint64(b)), 0)) <= ttl
+}
+
+// digestHash returns the hash of the algorithm, or nil if it is not supported.
+func digestHash(algorithm string) func() hash.Hash {
+	switch strings.ToUpper(algorithm) {
+	case "", "MD5":
+		return md5.New
+	case "SHA-256":
+		return sha256.New
+	}
+	return nil
+}
+
+func hexHash(h func() hash.Hash, s string) string {
+	d := h()
+	d.Write([]byte(s))
+	return hex.EncodeToString(d.Sum(nil))
+}
+
+// parseAuthParams parses the comma separated name=value pairs of an
+// Authorization header, where the values may be quoted strings.
+func parseAuthParams(s string) map[string]string {
+	p := make(map[string]string)
+	for {
+		s = strings.TrimLeft(s, " \t,")
+		name, rest, ok := strings.Cut(s, "=")
+		if !ok {
+			return p
+		}
+		name = strings.ToLower(strings.TrimSpace(name))
+		rest = strings.TrimLeft(rest, " \t")
+		var value strings.Builder
+		if strings.HasPrefix(rest, `"`) {
+			i := 1
+			for ; i < len(rest) && rest[i] != '"'; i++ {
+				if rest[i] == '\\' && i+1 < len(rest) {
+					i++
+				}
+				value.WriteByte(rest[i])
+			}
+			s = rest[min(i+1, len(rest)):]
+		} else {
+			v, after, _ := strings.Cut(rest, ",")
+			value.WriteString(strings.TrimSpace(v))
+			s = after
+		}
+		p[name] = value.String()
+	}
+}
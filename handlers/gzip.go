@@ -7,10 +7,12 @@ package handlers
 
 import (
 	"compress/gzip"
-	"crypto/tls"
+	"fmt"
 	"io"
 	"io/ioutil"
+	"mime"
 	"net/http"
+	"strconv"
 	"strings"
 	"sync"
 )
@@ -27,7 +29,66 @@ func (w IOResponseWriter) WriteHeader(i int) {
 	w.ResponseWriter.WriteHeader(i)
 }
 
-// Handle provides on-the-fly gzip encoding for other handlers.
+// FlushError flushes the Writer, if it buffers, and then the ResponseWriter,
+// so streamed responses (eg. Server-Sent Events) are not held back.
+func (w IOResponseWriter) FlushError() error {
+	if f, ok := w.Writer.(interface {
+		Flush() error
+	}); ok {
+		if err := f.Flush(); err != nil {
+			return err
+		}
+	}
+	return http.NewResponseController(w.ResponseWriter).Flush()
+}
+func (w IOResponseWriter) Flush() {
+	w.FlushError()
+}
+
+// Unwrap returns the wrapped ResponseWriter. It is used by http.ResponseController.
+func (w IOResponseWriter) Unwrap() http.ResponseWriter {
+	return w.ResponseWriter
+}
+
+// GzipConfig configures the compression of responses. The zero value
+// compresses all responses with gzip, see DefaultGzipConfig for sensible
+// defaults.
+type GzipConfig struct {
+	// Encoders are the content codings offered, in order of preference when
+	// the client accepts several equally. Only GzipEncoder if nil.
+	Encoders []Encoder
+	// Level is the compression level of compress/flate, from
+	// gzip.HuffmanOnly (-2) to gzip.BestCompression (9). 0 is mapped to
+	// gzip.DefaultCompression, so gzip.NoCompression can not be set: leave
+	// out the handler to send responses uncompressed. Handler panics on
+	// levels out of range.
+	Level int
+	// MinSize is the minimum size of the compressed responses. The response
+	// is buffered until it reaches MinSize, unless it is flushed, and sent
+	// uncompressed if it ends before.
+	MinSize int
+	// Types, if set, are the only MIME types compressed. Types ending with
+	// a slash match all subtypes, eg. "text/".
+	Types []string
+	// ExcludeTypes are MIME types never compressed, matched like Types.
+	ExcludeTypes []string
+}
+
+// DefaultGzipConfig is the configuration used by Gzip. It skips small
+// responses and the formats which are already compressed.
+var DefaultGzipConfig = GzipConfig{
+	MinSize: 1024,
+	ExcludeTypes: []string{
+		"image/png", "image/jpeg", "image/gif", "image/webp", "image/avif",
+		"video/", "audio/", "font/woff", "font/woff2",
+		"application/zip", "application/gzip", "application/x-gzip",
+		"application/x-bzip2", "application/x-xz", "application/zstd",
+		"application/x-7z-compressed", "application/x-rar-compressed",
+	},
+}
+
+// Gzip provides on-the-fly gzip encoding for other handlers, with
+// DefaultGzipConfig.
 //
 // Usage:
 //
@@ -46,58 +107,242 @@ func (w IOResponseWriter) WriteHeader(i int) {
 //		http.ListenAndServe(":8080", Gzip(http.DefaultServeMux))
 //	}
 func Gzip(h http.Handler) http.HandlerFunc {
-	var pool sync.Pool
-	pool.New = func() interface{} {
-		return gzip.NewWriter(ioutil.Discard)
+	return DefaultGzipConfig.Handler(h)
+}
+
+// Compress provides on-the-fly gzip or deflate encoding for other handlers,
+// whichever the client prefers, with DefaultGzipConfig otherwise.
+func Compress(h http.Handler) http.HandlerFunc {
+	c := DefaultGzipConfig
+	c.Encoders = []Encoder{GzipEncoder, DeflateEncoder}
+	return c.Handler(h)
+}
+
+// Handler provides on-the-fly encoding for h.
+//
+// Responses are compressed with the Encoder preferred by the Accept-Encoding
+// header of the request, if the status has a body and the handler did not
+// set a Content-Encoding itself. Compressed responses lose their
+// Content-Length, and their ETag becomes weak, since the encoded bytes
+// differ. Vary: Accept-Encoding is set on all responses, so caches keep the
+// versions apart.
+//
+// Handler panics if an Encoder rejects the Level, so a bad configuration
+// fails on start rather than on the first compressed response.
+func (c GzipConfig) Handler(h http.Handler) http.HandlerFunc {
+	if c.Encoders == nil {
+		c.Encoders = []Encoder{GzipEncoder}
+	}
+	level := c.Level
+	if level == 0 {
+		level = gzip.DefaultCompression
+	}
+	// the pools are by index, the encoders may not be comparable
+	pools := make([]*sync.Pool, len(c.Encoders))
+	for i, e := range c.Encoders {
+		e := e
+		// the level is checked once, the writers of the pool then can not fail
+		first, err := e.NewWriter(ioutil.Discard, level)
+		if err != nil {
+			panic(fmt.Sprintf("handlers: %s level %d: %v", e.Coding(), c.Level, err))
+		}
+		pools[i] = &sync.Pool{New: func() interface{} {
+			ew, _ := e.NewWriter(ioutil.Discard, level)
+			return ew
+		}}
+		pools[i].Put(first)
 	}
 	return func(w http.ResponseWriter, r *http.Request) {
+		if !strings.Contains(strings.Join(w.Header().Values("Vary"), ","), "Accept-Encoding") {
+			w.Header().Add("Vary", "Accept-Encoding")
+		}
+		i := negotiateEncoding(r, c.Encoders)
 		// Do nothing on a HEAD request
-		if !strings.Contains(r.Header.Get("Accept-Encoding"), "gzip") || r.Method == "HEAD" ||
-			w.Header().Get("Content-Encoding") == "gzip" { // Skip compression if already compressed
-
+		if i < 0 || r.Method == "HEAD" {
 			h.ServeHTTP(w, r)
 			return
 		}
-		w.Header().Set("Content-Encoding", "gzip")
-		gz := pool.Get().(*gzip.Writer)
-		defer pool.Put(gz)
-		gz.Reset(w)
+		cw := &compressWriter{ResponseWriter: w, config: &c, coding: c.Encoders[i].Coding(), pool: pools[i]}
+		defer cw.close()
+		h.ServeHTTP(cw, r)
+	}
+}
+
+// typeAllowed checks if the MIME type may be compressed.
+func (c *GzipConfig) typeAllowed(contentType string) bool {
+	mediatype, _, err := mime.ParseMediaType(contentType)
+	if err != nil {
+		// unknown content, compress unless only some types are
+		return len(c.Types) == 0
+	}
+	if len(c.Types) > 0 && !matchType(c.Types, mediatype) {
+		return false
+	}
+	return !matchType(c.ExcludeTypes, mediatype)
+}
+
+func matchType(types []string, mediatype string) bool {
+	for _, t := range types {
+		if t == mediatype || strings.HasSuffix(t, "/") && strings.HasPrefix(mediatype, t) {
+			return true
+		}
+	}
+	return false
+}
+
+// bodyAllowed checks if a response with the status may have a body,
+// see http.bodyAllowedForStatus.
+func bodyAllowed(status int) bool {
+	return status >= 200 && status != http.StatusNoContent && status != http.StatusNotModified
+}
+
+// compressWriter buffers the response until it knows whether to compress it.
+type compressWriter struct {
+	http.ResponseWriter
+	config *GzipConfig
+	coding string
+	pool   *sync.Pool
+
+	status  int
+	decided bool
+	buf     []byte
+	enc     EncoderWriter
+}
+
+func (w *compressWriter) WriteHeader(status int) {
+	if w.status != 0 {
+		return
+	}
+	if status < 200 && status != http.StatusSwitchingProtocols {
+		// informational responses (eg. 103 Early Hints) are sent right away
+		w.ResponseWriter.WriteHeader(status)
+		return
+	}
+	w.status = status
+	h := w.Header()
+	if !bodyAllowed(status) || status == http.StatusPartialContent || h.Get("Content-Encoding") != "" {
+		w.decide(false)
+		return
+	}
+	if cl, err := strconv.Atoi(h.Get("Content-Length")); err == nil && cl < w.config.MinSize {
+		w.decide(false)
+	}
+}
+
+func (w *compressWriter) Write(b []byte) (int, error) {
+	if w.status == 0 {
+		w.WriteHeader(http.StatusOK)
+	}
+	if !w.decided {
+		w.buf = append(w.buf, b...)
+		if len(w.buf) < w.config.MinSize {
+			return len(b), nil
+		}
+		return len(b), w.decide(true)
+	}
+	if w.enc != nil {
+		return w.enc.Write(b)
+	}
+	return w.ResponseWriter.Write(b)
+}
+
+// decide sends the response headers, compressing the response if it may be
+// and the content type allows it, and then writes the buffered body.
+func (w *compressWriter) decide(compress bool) error {
+	w.decided = true
+	h := w.Header()
+	if compress && h.Get("Content-Type") == "" && len(w.buf) > 0 {
+		h.Set("Content-Type", http.DetectContentType(w.buf))
+	}
+	if compress && w.config.typeAllowed(h.Get("Content-Type")) {
+		h.Del("Content-Length")
+		h.Set("Content-Encoding", w.coding)
+		if etag := h.Get("ETag"); etag != "" && !strings.HasPrefix(etag, "W/") {
+			h.Set("ETag", "W/"+etag)
+		}
+		w.enc = w.pool.Get().(EncoderWriter)
+		w.enc.Reset(w.ResponseWriter)
+	}
+	w.ResponseWriter.WriteHeader(w.status)
+	if len(w.buf) == 0 {
+		return nil
+	}
+	var err error
+	if w.enc != nil {
+		_, err = w.enc.Write(w.buf)
+	} else {
+		_, err = w.ResponseWriter.Write(w.buf)
+	}
+	w.buf = nil
+	return err
+}
+
+// FlushError sends the buffered response, compressing it if the content
+// type allows it, so streamed responses (eg. Server-Sent Events) are not
+// held back.
+func (w *compressWriter) FlushError() error {
+	if w.status == 0 {
+		w.WriteHeader(http.StatusOK)
+	}
+	if !w.decided {
+		if err := w.decide(true); err != nil {
+			return err
+		}
+	}
+	if w.enc != nil {
+		if err := w.enc.Flush(); err != nil {
+			return err
+		}
+	}
+	return http.NewResponseController(w.ResponseWriter).Flush()
+}
+
+func (w *compressWriter) Flush() {
+	w.FlushError()
+}
 
-		h.ServeHTTP(IOResponseWriter{Writer: gz, ResponseWriter: WrapWriter(w)}, r)
-		gz.Close()
+// Unwrap returns the wrapped ResponseWriter. It is used by http.ResponseController.
+func (w *compressWriter) Unwrap() http.ResponseWriter {
+	return w.ResponseWriter
+}
+
+// close ends the response once the handler returns.
+func (w *compressWriter) close() {
+	if w.status == 0 {
+		// nothing written, the server sends the default response
+		return
+	}
+	if !w.decided {
+		// the whole response is below MinSize
+		w.decide(false)
+	}
+	if w.enc != nil {
+		w.enc.Close()
+		w.pool.Put(w.enc)
 	}
 }
 
 // GetGzipPage is an HTTP client that supports gzip encoding.
+// It returns the body of the response, whatever its status.
+//
+// Deprecated: GetGzipPage has no timeout nor size limit, use Client.Get.
+// Unlike before, TLS certificates are verified.
 func GetGzipPage(url string) ([]byte, error) {
-	tr := &http.Transport{
-		TLSClientConfig: &tls.Config{InsecureSkipVerify: true},
-	}
-	client := &http.Client{Transport: tr}
 	req, err := http.NewRequest("GET", url, nil)
 	if err != nil {
 		return nil, err
 	}
-	req.Header.Add("Accept-Encoding", "gzip, deflate")
-	resp, err := client.Do(req)
+	resp, err := gzipPageClient.Do(req)
 	if err != nil {
 		return nil, err
 	}
 	defer resp.Body.Close()
-	var body []byte
-	if resp.Header.Get("Content-Encoding") == "gzip" {
-		var gz *gzip.Reader
-		gz, err = gzip.NewReader(resp.Body)
-		if err != nil {
-			return nil, err
-		}
-		defer gz.Close()
-		body, err = ioutil.ReadAll(gz)
-	} else {
-		body, err = ioutil.ReadAll(resp.Body)
-	}
-	if err != nil {
-		return nil, err
-	}
-	return body, nil
+	return ioutil.ReadAll(resp.Body)
+}
+
+// gzipPageClient is shared by the calls of GetGzipPage, so connections are
+// reused.
+var gzipPageClient = &Client{
+	HTTPClient:  &http.Client{Transport: NewClient().HTTPClient.Transport},
+	MaxBodySize: 1 << 62,
 }
@@ -0,0 +1,133 @@
+package handlers
+
+import (
+	"compress/gzip"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func gzipGet(t *testing.T, h http.Handler) (*httptest.ResponseRecorder, string) {
+	t.Helper()
+	req := httptest.NewRequest("GET", "/", nil)
+	req.Header.Set("Accept-Encoding", "gzip")
+	w := httptest.NewRecorder()
+	h.ServeHTTP(w, req)
+	if w.Header().Get("Content-Encoding") != "gzip" {
+		return w, w.Body.String()
+	}
+	gz, err := gzip.NewReader(w.Body)
+	if err != nil {
+		t.Fatal(err)
+	}
+	body, err := io.ReadAll(gz)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return w, string(body)
+}
+
+func TestGzip(t *testing.T) {
+	large := strings.Repeat("a", 2048)
+	for _, c := range []struct {
+		name     string
+		status   int
+		header   map[string]string
+		body     string
+		compress bool
+	}{
+		{"small", 200, nil, "hello", false},
+		{"large", 200, nil, large, true},
+		{"large with length", 200, map[string]string{"Content-Length": "2048", "ETag": `"v1"`}, large, true},
+		{"small with length", 200, map[string]string{"Content-Length": "5"}, "hello", false},
+		{"image", 200, map[string]string{"Content-Type": "image/png"}, large, false},
+		{"svg", 200, map[string]string{"Content-Type": "image/svg+xml"}, large, true},
+		{"encoded", 200, map[string]string{"Content-Encoding": "br"}, large, false},
+		{"not modified", 304, nil, "", false},
+		{"partial", 206, nil, large, false},
+	} {
+		h := Gzip(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			for k, v := range c.header {
+				w.Header().Set(k, v)
+			}
+			w.WriteHeader(c.status)
+			// written in pieces, to go through the buffering
+			for i := 0; i < len(c.body); i += 100 {
+				io.WriteString(w, c.body[i:min(i+100, len(c.body))])
+			}
+		}))
+		w, body := gzipGet(t, h)
+		if compressed := w.Header().Get("Content-Encoding") == "gzip"; compressed != c.compress {
+			t.Errorf("%s: expected compression %v, got %v", c.name, c.compress, compressed)
+		}
+		if w.Code != c.status || body != c.body {
+			t.Errorf("%s: unexpected response %d %q", c.name, w.Code, body)
+		}
+		if v := w.Header().Get("Vary"); v != "Accept-Encoding" {
+			t.Errorf("%s: unexpected Vary %q", c.name, v)
+		}
+		if !c.compress {
+			continue
+		}
+		if cl := w.Header().Get("Content-Length"); cl != "" {
+			t.Errorf("%s: unexpected Content-Length %s", c.name, cl)
+		}
+		if etag := w.Header().Get("ETag"); c.header["ETag"] != "" && etag != "W/"+c.header["ETag"] {
+			t.Errorf("%s: expected a weak ETag, got %q", c.name, etag)
+		}
+	}
+}
+
+func TestGzipConfig(t *testing.T) {
+	h := GzipConfig{Types: []string{"text/"}}.Handler(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", r.URL.Query().Get("type"))
+		io.WriteString(w, "hello")
+	}))
+	for typ, compress := range map[string]bool{"text/plain": true, "application/json": false} {
+		req := httptest.NewRequest("GET", "/?type="+typ, nil)
+		req.Header.Set("Accept-Encoding", "gzip")
+		w := httptest.NewRecorder()
+		h.ServeHTTP(w, req)
+		if compressed := w.Header().Get("Content-Encoding") == "gzip"; compressed != compress {
+			t.Errorf("%s: expected compression %v, got %v", typ, compress, compressed)
+		}
+	}
+}
+
+func TestGzipFlush(t *testing.T) {
+	flushed := make(chan string, 1)
+	rec := httptest.NewRecorder()
+	h := Gzip(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/event-stream")
+		io.WriteString(w, "data: hello\n\n")
+		http.NewResponseController(w).Flush()
+		// the event is sent, despite being below MinSize
+		gz, err := gzip.NewReader(strings.NewReader(rec.Body.String()))
+		if err != nil {
+			t.Fatal(err)
+		}
+		var buf [64]byte
+		n, _ := gz.Read(buf[:])
+		flushed <- string(buf[:n])
+	}))
+	req := httptest.NewRequest("GET", "/", nil)
+	req.Header.Set("Accept-Encoding", "gzip")
+	h.ServeHTTP(rec, req)
+	if got := <-flushed; got != "data: hello\n\n" || !rec.Flushed {
+		t.Errorf("expected the event to be flushed, got %q", got)
+	}
+	if rec.Header().Get("Content-Encoding") != "gzip" {
+		t.Errorf("expected a gzip encoded stream, got %v", rec.Header())
+	}
+}
+
+func TestGzipConfigLevel(t *testing.T) {
+	defer func() {
+		if recover() == nil {
+			t.Error("expected a panic for an invalid level")
+		}
+	}()
+	GzipConfig{Level: 42}.Handler(http.NotFoundHandler())
+}
@@ -16,38 +16,194 @@
 package handlers
 
 import (
+	"net"
 	"net/http"
+	"strconv"
 	"strings"
+	"time"
 )
 
 // Handler which check if request is from canonical host and uses https. Otherwise will
 // redirect to https://<canonicalhost>/rest/of/the/url
+//
+// Behind a reverse proxy the scheme is taken from the Forwarded (RFC 7239)
+// or X-Forwarded-Proto header. Set TrustedProxies so those headers are only
+// believed when they come from the proxies, and not from spoofing clients.
+//
+// Usage example, with ACME challenges and health checks served over http:
+//
+//	handlers.ForceHTTPS{
+//		CanonicalHost:  "example.com",
+//		Next:           mux,
+//		HSTSMaxAge:     365 * 24 * time.Hour,
+//		ExemptPaths:    []string{"/.well-known/acme-challenge/", "/healthz"},
+//		TrustedProxies: []string{"10.0.0.0/8"},
+//	}
 type ForceHTTPS struct {
+	// CanonicalHost is the host redirected to. The request host if empty.
 	CanonicalHost string
 	Next          http.Handler
+
+	// HSTSMaxAge, if set, adds a Strict-Transport-Security header to the
+	// https responses, so browsers use https on their own for that long.
+	HSTSMaxAge            time.Duration
+	HSTSIncludeSubDomains bool
+	HSTSPreload           bool
+
+	// ExemptPaths are path prefixes served over http too, without redirect.
+	ExemptPaths []string
+	// ExemptHosts are hosts served over http too, without redirect.
+	ExemptHosts []string
+	// RedirectStatus is http.StatusMovedPermanently (the default if 0) or
+	// http.StatusPermanentRedirect, which keeps the method and body.
+	RedirectStatus int
+	// TrustedProxies are the IP addresses or CIDR ranges of the proxies
+	// whose headers are trusted. If nil, the headers of all peers are.
+	TrustedProxies []string
 }
 
 func (this ForceHTTPS) ServeHTTP(w http.ResponseWriter, req *http.Request) {
-	is_http := true
-	if h, ok := req.Header["X-Forwarded-Proto"]; ok {
-		if h[0] == "https" {
-			is_http = false
+	hostPort := strings.Split(req.Host, ":")
+	if this.isHTTPS(req) {
+		if this.CanonicalHost == "" || hostPort[0] == this.CanonicalHost {
+			if this.HSTSMaxAge > 0 {
+				w.Header().Set("Strict-Transport-Security", this.hsts())
+			}
+			this.Next.ServeHTTP(w, req)
+			return
 		}
+	} else if this.exempt(req, hostPort[0]) {
+		this.Next.ServeHTTP(w, req)
+		return
 	}
-	hostPort := strings.Split(req.Host, ":")
-	if is_http || hostPort[0] != this.CanonicalHost {
+
+	if this.CanonicalHost != "" {
 		hostPort[0] = this.CanonicalHost
-		url := "https://" + strings.Join(hostPort, ":") + req.URL.String()
-		http.Redirect(w, req, url, http.StatusMovedPermanently)
-		return
 	}
+	url := "https://" + strings.Join(hostPort, ":") + req.URL.RequestURI()
+	status := this.RedirectStatus
+	if status == 0 {
+		status = http.StatusMovedPermanently
+	}
+	http.Redirect(w, req, url, status)
+}
+
+// isHTTPS checks if the client connected with https, to this server or to
+// a trusted proxy.
+//
+// Proxies append to the forwarding headers, so the values on the left may
+// come from the client. They are walked from the right, the value of the
+// last hop, and the hops coming from a trusted proxy are skipped: the
+// scheme is the one seen by the first proxy, the one the client talked to.
+func (this ForceHTTPS) isHTTPS(req *http.Request) bool {
+	if req.TLS != nil {
+		return true
+	}
+	if !this.trusted(req.RemoteAddr) {
+		return false
+	}
+	if fwd := req.Header.Values("Forwarded"); len(fwd) > 0 {
+		elements := strings.Split(strings.Join(fwd, ","), ",")
+		proto := ""
+		for i := len(elements) - 1; i >= 0; i-- {
+			var from string
+			proto, from = "", ""
+			for _, pair := range strings.Split(elements[i], ";") {
+				name, value, _ := strings.Cut(strings.TrimSpace(pair), "=")
+				switch strings.ToLower(name) {
+				case "proto":
+					proto = strings.Trim(value, `"`)
+				case "for":
+					from = strings.Trim(value, `"`)
+				}
+			}
+			if !this.trusted(from) {
+				break
+			}
+		}
+		return strings.EqualFold(proto, "https")
+	}
+	if proto := headerList(req, "X-Forwarded-Proto"); len(proto) > 0 {
+		// the proxies append to X-Forwarded-For along with X-Forwarded-Proto
+		i := len(proto) - 1
+		from := headerList(req, "X-Forwarded-For")
+		for j := len(from) - 1; j >= 0 && i > 0 && this.trusted(from[j]); j-- {
+			i--
+		}
+		return strings.EqualFold(proto[i], "https")
+	}
+	return false
+}
+
+// headerList returns the comma separated values of the header.
+func headerList(req *http.Request, name string) []string {
+	var list []string
+	for _, v := range req.Header.Values(name) {
+		for _, item := range strings.Split(v, ",") {
+			list = append(list, strings.TrimSpace(item))
+		}
+	}
+	return list
+}
+
+// trusted checks if the peer, an address with or without port, is one of
+// the TrustedProxies.
+func (this ForceHTTPS) trusted(remoteAddr string) bool {
+	if this.TrustedProxies == nil {
+		return true
+	}
+	host, _, err := net.SplitHostPort(remoteAddr)
+	if err != nil {
+		host = remoteAddr
+	}
+	// Forwarded quotes IPv6 addresses in brackets
+	ip := net.ParseIP(strings.Trim(host, "[]"))
+	if ip == nil {
+		return false
+	}
+	for _, p := range this.TrustedProxies {
+		if _, network, err := net.ParseCIDR(p); err == nil {
+			if network.Contains(ip) {
+				return true
+			}
+		} else if ip.Equal(net.ParseIP(p)) {
+			return true
+		}
+	}
+	return false
+}
+
+func (this ForceHTTPS) exempt(req *http.Request, host string) bool {
+	for _, h := range this.ExemptHosts {
+		if strings.EqualFold(h, host) {
+			return true
+		}
+	}
+	for _, p := range this.ExemptPaths {
+		if strings.HasPrefix(req.URL.Path, p) {
+			return true
+		}
+	}
+	return false
+}
 
-	this.Next.ServeHTTP(w, req)
+func (this ForceHTTPS) hsts() string {
+	v := "max-age=" + strconv.Itoa(int(this.HSTSMaxAge/time.Second))
+	if this.HSTSIncludeSubDomains {
+		v += "; includeSubDomains"
+	}
+	if this.HSTSPreload {
+		v += "; preload"
+	}
+	return v
 }
 
 // Ensures authentication for handlers. Otherwise call fallback.
+// If P is set it is used instead of A, and the resolved principal is
+// available to Next through GetPrincipal.
 type Auth struct {
 	A        Authenticator
+	P        PrincipalAuthenticator
 	Next     http.Handler
 	Fallback http.Handler
 }
@@ -55,6 +211,14 @@ type Auth struct {
 type Authenticator func(req *http.Request) bool
 
 func (this Auth) ServeHTTP(w http.ResponseWriter, req *http.Request) {
+	if this.P != nil {
+		if p, ok := this.P(req); ok {
+			this.Next.ServeHTTP(w, WithPrincipal(req, p))
+		} else {
+			this.Fallback.ServeHTTP(w, req)
+		}
+		return
+	}
 	if this.A(req) {
 		this.Next.ServeHTTP(w, req)
 	} else {
@@ -0,0 +1,64 @@
+package handlers
+
+import (
+	"crypto/tls"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestForceHTTPS(t *testing.T) {
+	h := ForceHTTPS{
+		CanonicalHost:         "example.com",
+		Next:                  http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}),
+		HSTSMaxAge:            time.Hour,
+		HSTSIncludeSubDomains: true,
+		ExemptPaths:           []string{"/.well-known/acme-challenge/"},
+		ExemptHosts:           []string{"internal"},
+		RedirectStatus:        http.StatusPermanentRedirect,
+		TrustedProxies:        []string{"10.0.0.0/8", "192.168.1.1"},
+	}
+	for _, c := range []struct {
+		name     string
+		host     string
+		path     string
+		remote   string
+		header   http.Header
+		tls      bool
+		status   int
+		location string
+	}{
+		{"http", "example.com", "/a?b=c", "1.2.3.4:1", nil, false, 308, "https://example.com/a?b=c"},
+		{"tls", "example.com", "/", "1.2.3.4:1", nil, true, 200, ""},
+		{"other host", "www.example.com:8443", "/", "1.2.3.4:1", nil, true, 308, "https://example.com:8443/"},
+		{"proxy", "example.com", "/", "10.1.2.3:1", http.Header{"X-Forwarded-Proto": {"https"}}, false, 200, ""},
+		{"forwarded", "example.com", "/", "192.168.1.1:1", http.Header{"Forwarded": {`for=1.2.3.4;proto="https", for=10.0.0.1;proto=http`}}, false, 200, ""},
+		{"forwarded http", "example.com", "/", "10.1.2.3:1", http.Header{"Forwarded": {"for=1.2.3.4;proto=http"}}, false, 308, "https://example.com/"},
+		{"appended forwarded", "example.com", "/", "10.0.0.1:1", http.Header{"Forwarded": {"proto=https, for=5.6.7.8;proto=http"}}, false, 308, "https://example.com/"},
+		{"appended proto", "example.com", "/", "10.0.0.1:1", http.Header{"X-Forwarded-Proto": {"https, http"}}, false, 308, "https://example.com/"},
+		{"proxy chain", "example.com", "/", "10.0.0.1:1", http.Header{"X-Forwarded-Proto": {"http", "https, http"}, "X-Forwarded-For": {"1.2.3.4, 10.0.0.2"}}, false, 200, ""},
+		{"forwarded ipv6", "example.com", "/", "10.0.0.1:1", http.Header{"Forwarded": {`for="[2001:db8::1]:4711";proto=https`}}, false, 200, ""},
+		{"spoofed", "example.com", "/", "1.2.3.4:1", http.Header{"X-Forwarded-Proto": {"https"}}, false, 308, "https://example.com/"},
+		{"exempt path", "example.com", "/.well-known/acme-challenge/x", "1.2.3.4:1", nil, false, 200, ""},
+		{"exempt host", "internal", "/", "1.2.3.4:1", nil, false, 200, ""},
+	} {
+		req := httptest.NewRequest("GET", "http://"+c.host+c.path, nil)
+		req.RemoteAddr = c.remote
+		for k, v := range c.header {
+			req.Header[k] = v
+		}
+		if c.tls {
+			req.TLS = &tls.ConnectionState{}
+		}
+		w := httptest.NewRecorder()
+		h.ServeHTTP(w, req)
+		if w.Code != c.status || w.Header().Get("Location") != c.location {
+			t.Errorf("%s: unexpected response %d %q", c.name, w.Code, w.Header().Get("Location"))
+		}
+		hsts := w.Header().Get("Strict-Transport-Security")
+		if secure := c.status == 200 && c.name != "exempt path" && c.name != "exempt host"; secure != (hsts == "max-age=3600; includeSubDomains") {
+			t.Errorf("%s: unexpected Strict-Transport-Security %q", c.name, hsts)
+		}
+	}
+}
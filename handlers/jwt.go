@@ -0,0 +1,424 @@
+package handlers
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/hmac"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/sha512"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/json"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"hash"
+	"math/big"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+var (
+	ErrTokenMalformed   = errors.New("Malformed token")
+	ErrTokenSignature   = errors.New("Invalid token signature")
+	ErrTokenExpired     = errors.New("Token is expired")
+	ErrTokenNotYetValid = errors.New("Token is not valid yet")
+	ErrTokenIssuer      = errors.New("Invalid token issuer")
+	ErrTokenAudience    = errors.New("Invalid token audience")
+	ErrUnknownKey       = errors.New("Unknown token key")
+	ErrNoToken          = errors.New("No bearer token")
+)
+
+// JWTKeys are the keys verifying JSON Web Tokens, by key id (kid). A key is
+// a []byte secret (HS256, HS384, HS512), an *rsa.PublicKey (RS256) or an
+// *ecdsa.PublicKey on the P-256 curve (ES256). The zero value is ready to
+// use, and safe for concurrent use, so keys may be rotated while serving.
+type JWTKeys struct {
+	mu   sync.RWMutex
+	keys map[string]interface{}
+}
+
+// Add adds or replaces the key.
+func (k *JWTKeys) Add(kid string, key interface{}) {
+	k.mu.Lock()
+	if k.keys == nil {
+		k.keys = make(map[string]interface{})
+	}
+	k.keys[kid] = key
+	k.mu.Unlock()
+}
+
+// Remove removes the key.
+func (k *JWTKeys) Remove(kid string) {
+	k.mu.Lock()
+	delete(k.keys, kid)
+	k.mu.Unlock()
+}
+
+// LoadPEM adds the public key, or the public key of the certificate, of the
+// PEM file.
+func (k *JWTKeys) LoadPEM(kid, path string) error {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return err
+	}
+	block, _ := pem.Decode(data)
+	if block == nil {
+		return fmt.Errorf("%s: no PEM data", path)
+	}
+	var key interface{}
+	switch block.Type {
+	case "PUBLIC KEY":
+		key, err = x509.ParsePKIXPublicKey(block.Bytes)
+	case "RSA PUBLIC KEY":
+		key, err = x509.ParsePKCS1PublicKey(block.Bytes)
+	case "CERTIFICATE":
+		var cert *x509.Certificate
+		if cert, err = x509.ParseCertificate(block.Bytes); err == nil {
+			key = cert.PublicKey
+		}
+	default:
+		return fmt.Errorf("%s: unsupported PEM block %s", path, block.Type)
+	}
+	if err != nil {
+		return fmt.Errorf("%s: %v", path, err)
+	}
+	k.Add(kid, key)
+	return nil
+}
+
+// LoadJWKS replaces all the keys by the ones of the JSON Web Key Set file
+// (RFC 7517). Loading the file again, after it was updated, rotates the keys.
+// The keys which are not for signatures, or not supported (eg. OKP keys or
+// other curves than P-256), are skipped; it fails if no key is left.
+func (k *JWTKeys) LoadJWKS(path string) error {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return err
+	}
+	var set struct {
+		Keys []jwk `json:"keys"`
+	}
+	if err := json.Unmarshal(data, &set); err != nil {
+		return fmt.Errorf("%s: %v", path, err)
+	}
+	keys := make(map[string]interface{}, len(set.Keys))
+	var skipped error
+	for _, j := range set.Keys {
+		if j.Use != "" && j.Use != "sig" {
+			continue
+		}
+		key, err := j.publicKey()
+		if err != nil {
+			if skipped == nil {
+				skipped = fmt.Errorf("key %q: %v", j.Kid, err)
+			}
+			continue
+		}
+		keys[j.Kid] = key
+	}
+	if len(keys) == 0 {
+		if skipped != nil {
+			return fmt.Errorf("%s: no usable signature key, %v", path, skipped)
+		}
+		return fmt.Errorf("%s: no signature key", path)
+	}
+	k.mu.Lock()
+	k.keys = keys
+	k.mu.Unlock()
+	return nil
+}
+
+// key returns the key with the id. A token without kid is verified with
+// the only key, if there is a single one.
+func (k *JWTKeys) key(kid string) (interface{}, bool) {
+	if k == nil {
+		return nil, false
+	}
+	k.mu.RLock()
+	defer k.mu.RUnlock()
+	if kid == "" && len(k.keys) == 1 {
+		for _, key := range k.keys {
+			return key, true
+		}
+	}
+	key, ok := k.keys[kid]
+	return key, ok
+}
+
+// jwk is a JSON Web Key.
+type jwk struct {
+	Kty string `json:"kty"`
+	Kid string `json:"kid"`
+	Use string `json:"use"`
+	N   string `json:"n"`
+	E   string `json:"e"`
+	Crv string `json:"crv"`
+	X   string `json:"x"`
+	Y   string `json:"y"`
+	K   string `json:"k"`
+}
+
+func (j jwk) publicKey() (interface{}, error) {
+	b64 := base64.RawURLEncoding.DecodeString
+	switch j.Kty {
+	case "oct":
+		k, err := b64(j.K)
+		if err != nil {
+			return nil, err
+		}
+		// shorter secrets than the hash of HS256 are guessable
+		if len(k) < sha256.Size {
+			return nil, fmt.Errorf("oct key shorter than %d bytes", sha256.Size)
+		}
+		return k, nil
+	case "RSA":
+		n, err := b64(j.N)
+		if err != nil {
+			return nil, err
+		}
+		e, err := b64(j.E)
+		if err != nil {
+			return nil, err
+		}
+		return &rsa.PublicKey{N: new(big.Int).SetBytes(n), E: int(new(big.Int).SetBytes(e).Int64())}, nil
+	case "EC":
+		if j.Crv != "P-256" {
+			return nil, fmt.Errorf("unsupported curve %s", j.Crv)
+		}
+		x, err := b64(j.X)
+		if err != nil {
+			return nil, err
+		}
+		y, err := b64(j.Y)
+		if err != nil {
+			return nil, err
+		}
+		return &ecdsa.PublicKey{Curve: elliptic.P256(), X: new(big.Int).SetBytes(x), Y: new(big.Int).SetBytes(y)}, nil
+	}
+	return nil, fmt.Errorf("unsupported key type %s", j.Kty)
+}
+
+// JWTAuth authenticates the requests with a JSON Web Token (RFC 7519) in
+// the Authorization header: "Authorization: Bearer <token>". The principal
+// of the authenticated requests, see GetPrincipal, has the "sub" claim as
+// ID, the "roles" claim as Roles and all the claims as Claims.
+//
+// Usage example:
+//
+//	var keys handlers.JWTKeys
+//	if err := keys.LoadJWKS("/etc/app/jwks.json"); err != nil {
+//		log.Fatal(err)
+//	}
+//	auth := handlers.JWTAuth{Keys: &keys, Issuer: "https://auth.example.com", Audience: "api"}
+//	http.Handle("/api/", auth.Handler(apiMux))
+type JWTAuth struct {
+	// Keys verify the tokens. If nil every token fails with ErrUnknownKey.
+	Keys *JWTKeys
+	// Issuer, if set, is the required "iss" claim.
+	Issuer string
+	// Audience, if set, must be in the "aud" claim.
+	Audience string
+	// Leeway is the tolerated clock skew when checking "exp" and "nbf".
+	Leeway time.Duration
+	// Realm is sent in the WWW-Authenticate challenge.
+	Realm string
+}
+
+// Verify checks the token and returns its claims.
+func (this JWTAuth) Verify(token string) (map[string]interface{}, error) {
+	parts := strings.Split(token, ".")
+	if len(parts) != 3 {
+		return nil, ErrTokenMalformed
+	}
+	var header struct {
+		Alg string `json:"alg"`
+		Kid string `json:"kid"`
+	}
+	if err := decodeSegment(parts[0], &header); err != nil {
+		return nil, ErrTokenMalformed
+	}
+	sig, err := base64.RawURLEncoding.DecodeString(parts[2])
+	if err != nil {
+		return nil, ErrTokenMalformed
+	}
+	key, ok := this.Keys.key(header.Kid)
+	if !ok {
+		return nil, ErrUnknownKey
+	}
+	if !verifySignature(header.Alg, key, parts[0]+"."+parts[1], sig) {
+		return nil, ErrTokenSignature
+	}
+
+	var claims map[string]interface{}
+	if err := decodeSegment(parts[1], &claims); err != nil {
+		return nil, ErrTokenMalformed
+	}
+	exp, hasExp, err := numericDate(claims, "exp")
+	if err != nil {
+		return nil, err
+	}
+	nbf, hasNbf, err := numericDate(claims, "nbf")
+	if err != nil {
+		return nil, err
+	}
+	now := time.Now()
+	if hasExp && now.After(exp.Add(this.Leeway)) {
+		return nil, ErrTokenExpired
+	}
+	if hasNbf && now.Add(this.Leeway).Before(nbf) {
+		return nil, ErrTokenNotYetValid
+	}
+	if this.Issuer != "" && claims["iss"] != this.Issuer {
+		return nil, ErrTokenIssuer
+	}
+	if this.Audience != "" && !hasAudience(claims["aud"], this.Audience) {
+		return nil, ErrTokenAudience
+	}
+	return claims, nil
+}
+
+// Principal is a PrincipalAuthenticator checking the bearer token of the
+// request.
+func (this JWTAuth) Principal(req *http.Request) (*Principal, bool) {
+	claims, err := this.verifyRequest(req)
+	if err != nil {
+		return nil, false
+	}
+	return jwtPrincipal(claims), true
+}
+
+// jwtPrincipal returns the principal of the claims of a token.
+func jwtPrincipal(claims map[string]interface{}) *Principal {
+	p := &Principal{Claims: claims}
+	p.ID, _ = claims["sub"].(string)
+	if roles, ok := claims["roles"].([]interface{}); ok {
+		for _, r := range roles {
+			if s, ok := r.(string); ok {
+				p.Roles = append(p.Roles, s)
+			}
+		}
+	}
+	return p
+}
+
+// Challenge answers 401 Unauthorized, with a WWW-Authenticate header
+// telling why the token was rejected (RFC 6750). It is the Fallback of an
+// Auth with Principal; it verifies the token again to tell why, which
+// Handler avoids.
+func (this JWTAuth) Challenge(w http.ResponseWriter, req *http.Request) {
+	_, err := this.verifyRequest(req)
+	this.challenge(w, err)
+}
+
+func (this JWTAuth) challenge(w http.ResponseWriter, err error) {
+	challenge := "Bearer realm=" + strconv.Quote(this.Realm)
+	if err != nil && err != ErrNoToken {
+		challenge += `, error="invalid_token", error_description=` + strconv.Quote(err.Error())
+	}
+	w.Header().Set("WWW-Authenticate", challenge)
+	http.Error(w, http.StatusText(http.StatusUnauthorized), http.StatusUnauthorized)
+}
+
+// Handler returns a handler protecting next, like an Auth with Principal
+// and Challenge, but verifying the token once.
+func (this JWTAuth) Handler(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		claims, err := this.verifyRequest(req)
+		if err != nil {
+			this.challenge(w, err)
+			return
+		}
+		next.ServeHTTP(w, WithPrincipal(req, jwtPrincipal(claims)))
+	})
+}
+
+func (this JWTAuth) verifyRequest(req *http.Request) (map[string]interface{}, error) {
+	scheme, token, _ := strings.Cut(req.Header.Get("Authorization"), " ")
+	if !strings.EqualFold(scheme, "Bearer") || token == "" {
+		return nil, ErrNoToken
+	}
+	return this.Verify(strings.TrimSpace(token))
+}
+
+func decodeSegment(s string, v interface{}) error {
+	b, err := base64.RawURLEncoding.DecodeString(s)
+	if err != nil {
+		return err
+	}
+	return json.Unmarshal(b, v)
+}
+
+// verifySignature checks the signature of the signed content with the
+// algorithm, which must match the type of the key.
+func verifySignature(alg string, key interface{}, signed string, sig []byte) bool {
+	switch k := key.(type) {
+	case []byte:
+		var h func() hash.Hash
+		switch alg {
+		case "HS256":
+			h = sha256.New
+		case "HS384":
+			h = sha512.New384
+		case "HS512":
+			h = sha512.New
+		default:
+			return false
+		}
+		mac := hmac.New(h, k)
+		mac.Write([]byte(signed))
+		return hmac.Equal(mac.Sum(nil), sig)
+	case *rsa.PublicKey:
+		if alg != "RS256" {
+			return false
+		}
+		digest := sha256.Sum256([]byte(signed))
+		return rsa.VerifyPKCS1v15(k, crypto.SHA256, digest[:], sig) == nil
+	case *ecdsa.PublicKey:
+		if alg != "ES256" || k.Curve != elliptic.P256() || len(sig) != 64 {
+			return false
+		}
+		digest := sha256.Sum256([]byte(signed))
+		r, s := new(big.Int).SetBytes(sig[:32]), new(big.Int).SetBytes(sig[32:])
+		return ecdsa.Verify(k, digest[:], r, s)
+	}
+	return false
+}
+
+// numericDate returns the date of the claim, if present. It must be a
+// number of seconds since the epoch.
+func numericDate(claims map[string]interface{}, name string) (t time.Time, ok bool, err error) {
+	v, ok := claims[name]
+	if !ok {
+		return time.Time{}, false, nil
+	}
+	n, isNumber := v.(float64)
+	if !isNumber {
+		return time.Time{}, false, ErrTokenMalformed
+	}
+	return unixTime(n), true, nil
+}
+
+func unixTime(t float64) time.Time {
+	return time.Unix(int64(t), 0)
+}
+
+func hasAudience(aud interface{}, audience string) bool {
+	switch a := aud.(type) {
+	case string:
+		return a == audience
+	case []interface{}:
+		for _, v := range a {
+			if v == audience {
+				return true
+			}
+		}
+	}
+	return false
+}
@@ -0,0 +1,235 @@
+package handlers
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/json"
+	"encoding/pem"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+)
+
+func signJWT(t *testing.T, alg, kid string, key interface{}, claims map[string]interface{}) string {
+	header, _ := json.Marshal(map[string]string{"alg": alg, "typ": "JWT", "kid": kid})
+	payload, _ := json.Marshal(claims)
+	signed := base64.RawURLEncoding.EncodeToString(header) + "." + base64.RawURLEncoding.EncodeToString(payload)
+	digest := sha256.Sum256([]byte(signed))
+	var sig []byte
+	switch k := key.(type) {
+	case []byte:
+		mac := hmac.New(sha256.New, k)
+		mac.Write([]byte(signed))
+		sig = mac.Sum(nil)
+	case *rsa.PrivateKey:
+		var err error
+		if sig, err = rsa.SignPKCS1v15(rand.Reader, k, crypto.SHA256, digest[:]); err != nil {
+			t.Fatal(err)
+		}
+	case *ecdsa.PrivateKey:
+		r, s, err := ecdsa.Sign(rand.Reader, k, digest[:])
+		if err != nil {
+			t.Fatal(err)
+		}
+		sig = append(r.FillBytes(make([]byte, 32)), s.FillBytes(make([]byte, 32))...)
+	}
+	return signed + "." + base64.RawURLEncoding.EncodeToString(sig)
+}
+
+func TestJWTAuth(t *testing.T) {
+	rsaKey, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ecKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	der, _ := x509.MarshalPKIXPublicKey(&rsaKey.PublicKey)
+	file := filepath.Join(t.TempDir(), "rsa.pem")
+	if err := os.WriteFile(file, pem.EncodeToMemory(&pem.Block{Type: "PUBLIC KEY", Bytes: der}), 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	var keys JWTKeys
+	if err := keys.LoadPEM("rsa", file); err != nil {
+		t.Fatal(err)
+	}
+	keys.Add("ec", &ecKey.PublicKey)
+	keys.Add("hmac", []byte("secret"))
+	auth := JWTAuth{Keys: &keys, Issuer: "issuer", Audience: "api", Leeway: time.Minute, Realm: "api"}
+	h := auth.Handler(http.HandlerFunc(whoami))
+
+	now := time.Now().Unix()
+	valid := map[string]interface{}{"sub": "alice", "iss": "issuer", "aud": []string{"web", "api"}, "exp": now + 60}
+	with := func(name string, value interface{}) map[string]interface{} {
+		claims := map[string]interface{}{}
+		for k, v := range valid {
+			claims[k] = v
+		}
+		claims[name] = value
+		return claims
+	}
+	for _, c := range []struct {
+		token string
+		err   string
+	}{
+		{signJWT(t, "HS256", "hmac", []byte("secret"), valid), ""},
+		{signJWT(t, "RS256", "rsa", rsaKey, valid), ""},
+		{signJWT(t, "ES256", "ec", ecKey, valid), ""},
+		{signJWT(t, "HS256", "hmac", []byte("secret"), with("exp", now-30)), ""},
+		{signJWT(t, "HS256", "hmac", []byte("wrong"), valid), ErrTokenSignature.Error()},
+		// the algorithm must match the type of the key
+		{signJWT(t, "HS256", "rsa", []byte("secret"), valid), ErrTokenSignature.Error()},
+		{signJWT(t, "none", "hmac", nil, valid), ErrTokenSignature.Error()},
+		{signJWT(t, "HS256", "other", []byte("secret"), valid), ErrUnknownKey.Error()},
+		{signJWT(t, "HS256", "hmac", []byte("secret"), with("exp", now-120)), ErrTokenExpired.Error()},
+		{signJWT(t, "HS256", "hmac", []byte("secret"), with("nbf", now+120)), ErrTokenNotYetValid.Error()},
+		{signJWT(t, "HS256", "hmac", []byte("secret"), with("exp", strconv.FormatInt(now-120, 10))), ErrTokenMalformed.Error()},
+		{signJWT(t, "HS256", "hmac", []byte("secret"), with("nbf", nil)), ErrTokenMalformed.Error()},
+		{signJWT(t, "HS256", "hmac", []byte("secret"), with("iss", "other")), ErrTokenIssuer.Error()},
+		{signJWT(t, "HS256", "hmac", []byte("secret"), with("aud", "web")), ErrTokenAudience.Error()},
+		{"not.a-token", ErrTokenMalformed.Error()},
+	} {
+		req := httptest.NewRequest("GET", "/", nil)
+		req.Header.Set("Authorization", "Bearer "+c.token)
+		w := httptest.NewRecorder()
+		h.ServeHTTP(w, req)
+		if c.err == "" {
+			if w.Code != 200 || w.Body.String() != "alice" {
+				t.Errorf("%s: got %d %q", c.token, w.Code, w.Body)
+			}
+			continue
+		}
+		challenge := w.Header().Get("WWW-Authenticate")
+		if w.Code != 401 || !strings.Contains(challenge, `error="invalid_token"`) || !strings.Contains(challenge, c.err) {
+			t.Errorf("%s: got %d %q, want %q", c.token, w.Code, challenge, c.err)
+		}
+	}
+
+	w := httptest.NewRecorder()
+	h.ServeHTTP(w, httptest.NewRequest("GET", "/", nil))
+	if w.Code != 401 || w.Header().Get("WWW-Authenticate") != `Bearer realm="api"` {
+		t.Errorf("no token: got %d %q", w.Code, w.Header().Get("WWW-Authenticate"))
+	}
+}
+
+func TestJWTPrincipal(t *testing.T) {
+	var keys JWTKeys
+	keys.Add("", []byte("secret"))
+	token := signJWT(t, "HS256", "", []byte("secret"), map[string]interface{}{"sub": "alice", "roles": []string{"admin"}, "tenant": "acme"})
+	req := httptest.NewRequest("GET", "/", nil)
+	req.Header.Set("Authorization", "bearer "+token)
+	p, ok := JWTAuth{Keys: &keys}.Principal(req)
+	if !ok || p.ID != "alice" || !p.HasRole("admin") {
+		t.Fatalf("got %v %v", p, ok)
+	}
+	if tenant, _ := Claim[string](p, "tenant"); tenant != "acme" {
+		t.Errorf("tenant claim %q", tenant)
+	}
+}
+
+func TestJWKSRotation(t *testing.T) {
+	ecKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	b64 := func(n *big.Int) string { return base64.RawURLEncoding.EncodeToString(n.FillBytes(make([]byte, 32))) }
+	file := filepath.Join(t.TempDir(), "jwks.json")
+	write := func(kid string) {
+		jwks := `{"keys": [{"kty": "EC", "crv": "P-256", "use": "sig", "kid": "` + kid + `", "x": "` + b64(ecKey.X) + `", "y": "` + b64(ecKey.Y) + `"}]}`
+		if err := os.WriteFile(file, []byte(jwks), 0600); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	var keys JWTKeys
+	auth := JWTAuth{Keys: &keys}
+	write("2024")
+	if err := keys.LoadJWKS(file); err != nil {
+		t.Fatal(err)
+	}
+	old := signJWT(t, "ES256", "2024", ecKey, map[string]interface{}{"sub": "alice"})
+	if _, err := auth.Verify(old); err != nil {
+		t.Fatal(err)
+	}
+	write("2025")
+	if err := keys.LoadJWKS(file); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := auth.Verify(old); err != ErrUnknownKey {
+		t.Errorf("rotated key: got %v", err)
+	}
+	if _, err := auth.Verify(signJWT(t, "ES256", "2025", ecKey, map[string]interface{}{"sub": "alice"})); err != nil {
+		t.Error(err)
+	}
+}
+
+func TestJWKSUnsupportedKeys(t *testing.T) {
+	ecKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	b64 := func(n *big.Int) string { return base64.RawURLEncoding.EncodeToString(n.FillBytes(make([]byte, 32))) }
+	okp := `{"kty": "OKP", "crv": "Ed25519", "kid": "ed", "x": "11qYAYKxCrfVS_7TyWQHOg7hcvPapiMlrwIaaPcHURo"}`
+	p384 := `{"kty": "EC", "crv": "P-384", "kid": "p384", "x": "AA", "y": "AA"}`
+	enc := `{"kty": "RSA", "use": "enc", "kid": "enc", "n": "AQAB", "e": "AQAB"}`
+	empty := `{"kty": "oct", "kid": "empty"}`
+	short := `{"kty": "oct", "kid": "short", "k": "c2VjcmV0"}`
+	p256 := `{"kty": "EC", "crv": "P-256", "kid": "p256", "x": "` + b64(ecKey.X) + `", "y": "` + b64(ecKey.Y) + `"}`
+	file := filepath.Join(t.TempDir(), "jwks.json")
+	for _, c := range []struct {
+		keys []string
+		ok   bool
+	}{
+		{[]string{okp, p384, enc, empty, short, p256}, true},
+		{[]string{okp, p384, enc, empty, short}, false},
+		{nil, false},
+	} {
+		if err := os.WriteFile(file, []byte(`{"keys": [`+strings.Join(c.keys, ",")+`]}`), 0600); err != nil {
+			t.Fatal(err)
+		}
+		var keys JWTKeys
+		err := keys.LoadJWKS(file)
+		if c.ok != (err == nil) {
+			t.Errorf("%d keys: got %v", len(c.keys), err)
+		}
+		if err != nil {
+			continue
+		}
+		auth := JWTAuth{Keys: &keys}
+		if _, err := auth.Verify(signJWT(t, "ES256", "p256", ecKey, map[string]interface{}{"sub": "alice"})); err != nil {
+			t.Error(err)
+		}
+		if _, err := auth.Verify(signJWT(t, "ES256", "ed", ecKey, map[string]interface{}{"sub": "alice"})); err != ErrUnknownKey {
+			t.Errorf("skipped key: got %v", err)
+		}
+		if _, err := auth.Verify(signJWT(t, "HS256", "empty", []byte{}, map[string]interface{}{"sub": "alice"})); err != ErrUnknownKey {
+			t.Errorf("empty secret: got %v", err)
+		}
+	}
+}
+
+func TestJWTNoKeys(t *testing.T) {
+	ecKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	token := signJWT(t, "ES256", "", ecKey, map[string]interface{}{"sub": "alice"})
+	if _, err := (JWTAuth{}).Verify(token); err != ErrUnknownKey {
+		t.Errorf("got %v", err)
+	}
+}
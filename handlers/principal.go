@@ -0,0 +1,60 @@
+package handlers
+
+import (
+	"context"
+	"net/http"
+)
+
+// Principal is the authenticated caller of a request: a user or a service.
+// It is stored in the request context by Auth, and by the
+// authenticators of this package.
+type Principal struct {
+	ID     string
+	Roles  []string
+	Claims map[string]interface{}
+}
+
+// PrincipalAuthenticator authenticates the request and returns its caller.
+type PrincipalAuthenticator func(req *http.Request) (*Principal, bool)
+
+// String returns the ID, so a Principal is logged and printed as such.
+func (p *Principal) String() string {
+	return p.ID
+}
+
+// HasRole checks if the principal has the role.
+func (p *Principal) HasRole(role string) bool {
+	for _, r := range p.Roles {
+		if r == role {
+			return true
+		}
+	}
+	return false
+}
+
+// Claim returns the claim of the principal if it has the type T, eg.
+//
+//	tenant, ok := handlers.Claim[string](p, "tenant")
+func Claim[T any](p *Principal, name string) (T, bool) {
+	v, ok := p.Claims[name].(T)
+	return v, ok
+}
+
+type principalKey struct{}
+
+// WithPrincipal returns a shallow copy of req carrying the principal.
+func WithPrincipal(req *http.Request, p *Principal) *http.Request {
+	return req.WithContext(context.WithValue(req.Context(), principalKey{}, p))
+}
+
+// GetPrincipal returns the principal of the request, stored by Auth.
+func GetPrincipal(req *http.Request) (*Principal, bool) {
+	return PrincipalFromContext(req.Context())
+}
+
+// PrincipalFromContext returns the principal stored in the context of a
+// request, for code which has no access to the request itself.
+func PrincipalFromContext(ctx context.Context) (*Principal, bool) {
+	p, ok := ctx.Value(principalKey{}).(*Principal)
+	return p, ok && p != nil
+}
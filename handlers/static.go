@@ -0,0 +1,142 @@
+package handlers
+
+import (
+	"errors"
+	"fmt"
+	"io/fs"
+	"mime"
+	"net/http"
+	"path"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// DefaultFingerprint matches the file names with a content hash, as
+// produced by asset bundlers, eg. "app.3f9a1c2b.js" or "logo-3f9a1c2b.png".
+var DefaultFingerprint = regexp.MustCompile(`[.-][0-9a-fA-F]{8,}\.[^/]+$`)
+
+// Static serves the files of Root, preferring the precompressed "name.gz"
+// variant of a file when the client accepts gzip. Conditional and Range
+// requests are handled by http.ServeContent. Directories are served by their
+// index.html, and never listed.
+//
+// Static sets Content-Encoding on precompressed files, so wrapping it with
+// Gzip does not compress them again.
+//
+// Usage example:
+//
+//	http.Handle("/", handlers.Gzip(handlers.Static{Root: http.Dir("dist"), SPA: true}))
+type Static struct {
+	Root http.FileSystem
+	// Fingerprint matches the paths of the files whose name changes with
+	// their content, which are cached for a year. DefaultFingerprint if nil.
+	Fingerprint *regexp.Regexp
+	// MaxAge is the time other files are cached. 0 means browsers revalidate
+	// them on every use (Cache-Control: no-cache), through the ETag.
+	MaxAge time.Duration
+	// SPA serves /index.html instead of 404 Not Found to the requests for
+	// pages, so a single-page application handles its routes itself.
+	SPA bool
+}
+
+func (this Static) ServeHTTP(w http.ResponseWriter, req *http.Request) {
+	if req.Method != "GET" && req.Method != "HEAD" {
+		w.Header().Set("Allow", "GET, HEAD")
+		http.Error(w, http.StatusText(http.StatusMethodNotAllowed), http.StatusMethodNotAllowed)
+		return
+	}
+	name := path.Clean("/" + req.URL.Path)
+	err := this.serveFile(w, req, name)
+	if errors.Is(err, fs.ErrNotExist) && this.SPA && isPageRequest(req, name) {
+		err = this.serveFile(w, req, "/index.html")
+	}
+	switch {
+	case err == nil:
+	case errors.Is(err, fs.ErrNotExist):
+		http.NotFound(w, req)
+	case errors.Is(err, fs.ErrPermission):
+		http.Error(w, http.StatusText(http.StatusForbidden), http.StatusForbidden)
+	default:
+		http.Error(w, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
+	}
+}
+
+// serveFile serves the file, or its precompressed variant. Nothing is
+// written when an error is returned.
+func (this Static) serveFile(w http.ResponseWriter, req *http.Request, name string) error {
+	f, err := this.Root.Open(name)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	info, err := f.Stat()
+	if err != nil {
+		return err
+	}
+	if info.IsDir() {
+		name = path.Join(name, "index.html")
+		if f, err = this.Root.Open(name); err != nil {
+			return err
+		}
+		defer f.Close()
+		if info, err = f.Stat(); err != nil {
+			return err
+		}
+		if info.IsDir() {
+			return fs.ErrNotExist
+		}
+	}
+
+	h := w.Header()
+	ctype := mime.TypeByExtension(path.Ext(name))
+	tag := fmt.Sprintf("%x-%x", info.ModTime().UnixNano(), info.Size())
+	if gz, err := this.Root.Open(name + ".gz"); err == nil {
+		defer gz.Close()
+		if gzInfo, err := gz.Stat(); err == nil && !gzInfo.IsDir() {
+			if !strings.Contains(strings.Join(h.Values("Vary"), ","), "Accept-Encoding") {
+				h.Add("Vary", "Accept-Encoding")
+			}
+			if negotiateEncoding(req, []Encoder{GzipEncoder}) >= 0 {
+				if ctype == "" {
+					ctype = "application/octet-stream"
+				}
+				h.Set("Content-Encoding", "gzip")
+				tag += "-gz"
+				f, info = gz, gzInfo
+			}
+		}
+	}
+	if ctype != "" {
+		h.Set("Content-Type", ctype)
+	}
+	h.Set("ETag", `"`+tag+`"`)
+	h.Set("Cache-Control", this.cacheControl(name))
+	http.ServeContent(w, req, name, info.ModTime(), f)
+	return nil
+}
+
+func (this Static) cacheControl(name string) string {
+	fingerprint := this.Fingerprint
+	if fingerprint == nil {
+		fingerprint = DefaultFingerprint
+	}
+	switch {
+	case fingerprint.MatchString(name):
+		return "public, max-age=31536000, immutable"
+	case this.MaxAge > 0:
+		return "public, max-age=" + strconv.Itoa(int(this.MaxAge/time.Second))
+	}
+	return "no-cache"
+}
+
+// isPageRequest checks if the request is for a page, rather than an asset
+// or an API call, so the SPA fallback applies.
+func isPageRequest(req *http.Request, name string) bool {
+	if path.Ext(name) != "" {
+		return false
+	}
+	accept := req.Header.Get("Accept")
+	return accept == "" || strings.Contains(accept, "text/html") || strings.Contains(accept, "*/*")
+}
@@ -0,0 +1,70 @@
+package handlers
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"testing/fstest"
+)
+
+func TestStatic(t *testing.T) {
+	root := http.FS(fstest.MapFS{
+		"index.html":        {Data: []byte("<html>app</html>")},
+		"app.js":            {Data: []byte("console.log(1)")},
+		"app.js.gz":         {Data: []byte("gzipped")},
+		"app.3f9a1c2b.css":  {Data: []byte("body{}")},
+		"docs/index.html":   {Data: []byte("docs")},
+		"docs/guide/a.html": {Data: []byte("a")},
+	})
+	h := Static{Root: root, SPA: true}
+
+	get := func(path string, header http.Header) *httptest.ResponseRecorder {
+		req := httptest.NewRequest("GET", path, nil)
+		for k, v := range header {
+			req.Header[k] = v
+		}
+		w := httptest.NewRecorder()
+		h.ServeHTTP(w, req)
+		return w
+	}
+
+	w := get("/app.js", http.Header{"Accept-Encoding": {"gzip"}})
+	if w.Body.String() != "gzipped" || w.Header().Get("Content-Encoding") != "gzip" ||
+		w.Header().Get("Content-Type") != "text/javascript; charset=utf-8" ||
+		w.Header().Get("Vary") != "Accept-Encoding" {
+		t.Errorf("unexpected precompressed response %v %q", w.Header(), w.Body)
+	}
+	gzTag := w.Header().Get("ETag")
+
+	w = get("/app.js", nil)
+	if w.Body.String() != "console.log(1)" || w.Header().Get("Content-Encoding") != "" ||
+		w.Header().Get("Cache-Control") != "no-cache" {
+		t.Errorf("unexpected plain response %v %q", w.Header(), w.Body)
+	}
+	tag := w.Header().Get("ETag")
+	if tag == "" || tag == gzTag {
+		t.Errorf("expected distinct ETags, got %q and %q", tag, gzTag)
+	}
+
+	if w = get("/app.js", http.Header{"If-None-Match": {tag}}); w.Code != http.StatusNotModified {
+		t.Errorf("expected 304, got %d", w.Code)
+	}
+	if w = get("/app.js", http.Header{"Range": {"bytes=0-6"}}); w.Code != http.StatusPartialContent || w.Body.String() != "console" {
+		t.Errorf("unexpected range response %d %q", w.Code, w.Body)
+	}
+	if w = get("/app.3f9a1c2b.css", nil); w.Header().Get("Cache-Control") != "public, max-age=31536000, immutable" {
+		t.Errorf("unexpected Cache-Control %q", w.Header().Get("Cache-Control"))
+	}
+	if w = get("/docs/", nil); w.Body.String() != "docs" {
+		t.Errorf("unexpected directory response %q", w.Body)
+	}
+	if w = get("/settings/profile", http.Header{"Accept": {"text/html"}}); w.Code != 200 || w.Body.String() != "<html>app</html>" {
+		t.Errorf("unexpected SPA fallback %d %q", w.Code, w.Body)
+	}
+	if w = get("/missing.js", nil); w.Code != http.StatusNotFound {
+		t.Errorf("expected 404 for a missing asset, got %d", w.Code)
+	}
+	if w = get("/docs/guide", nil); w.Code != 200 || w.Body.String() != "<html>app</html>" {
+		t.Errorf("expected the SPA fallback for a directory without index, got %d %q", w.Code, w.Body)
+	}
+}
@@ -0,0 +1,53 @@
+// Copyright (c) 2017 Robert Zaremba
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package ozzohandlers
+
+import (
+	"net/http"
+
+	routing "github.com/go-ozzo/ozzo-routing"
+	"github.com/scale-it/go-web/handlers"
+)
+
+// Auth returns an ozzo routing.Handler which authenticates the request with
+// the PrincipalAuthenticator of handlers (eg. handlers.BasicAuth.Principal).
+// The principal is stored in the request context, like handlers.Auth does,
+// so it is read with Principal or handlers.GetPrincipal.
+//
+// Unauthenticated requests are served by fallback, which usually sends a
+// challenge (eg. http.HandlerFunc(basic.Challenge)), and the next handlers
+// are skipped. If fallback is nil they fail with a 401 Unauthorized
+// routing.HTTPError, without WWW-Authenticate header.
+func Auth(a handlers.PrincipalAuthenticator, fallback http.Handler) routing.Handler {
+	return func(c *routing.Context) error {
+		p, ok := a(c.Request)
+		if !ok {
+			if fallback == nil {
+				return routing.NewHTTPError(http.StatusUnauthorized)
+			}
+			fallback.ServeHTTP(c.Response, c.Request)
+			c.Abort()
+			return nil
+		}
+		c.Request = handlers.WithPrincipal(c.Request, p)
+		return c.Next()
+	}
+}
+
+// Principal returns the principal of the request, stored by Auth or by
+// handlers.Auth.
+func Principal(c *routing.Context) (*handlers.Principal, bool) {
+	return handlers.GetPrincipal(c.Request)
+}
@@ -0,0 +1,53 @@
+package ozzohandlers
+
+import (
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	routing "github.com/go-ozzo/ozzo-routing"
+	"github.com/scale-it/go-web/handlers"
+)
+
+func TestAuth(t *testing.T) {
+	authenticate := func(r *http.Request) (*handlers.Principal, bool) {
+		user, password, ok := r.BasicAuth()
+		return &handlers.Principal{ID: user}, ok && password == "secret"
+	}
+	whoami := func(c *routing.Context) error {
+		p, ok := Principal(c)
+		if !ok {
+			t.Error("expected a principal")
+			return nil
+		}
+		_, err := io.WriteString(c.Response, p.ID)
+		return err
+	}
+	challenge := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("WWW-Authenticate", `Basic realm="test"`)
+		w.WriteHeader(http.StatusUnauthorized)
+	})
+	router := routing.New()
+	router.Get("/challenge", Auth(authenticate, challenge), whoami)
+	router.Get("/plain", Auth(authenticate, nil), whoami)
+
+	for _, c := range []struct {
+		path, password string
+		status         int
+		body, header   string
+	}{
+		{"/challenge", "secret", 200, "alice", ""},
+		{"/challenge", "wrong", 401, "", `Basic realm="test"`},
+		{"/plain", "secret", 200, "alice", ""},
+		{"/plain", "wrong", 401, "Unauthorized\n", ""},
+	} {
+		req := httptest.NewRequest("GET", c.path, nil)
+		req.SetBasicAuth("alice", c.password)
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, req)
+		if w.Code != c.status || w.Body.String() != c.body || w.Header().Get("WWW-Authenticate") != c.header {
+			t.Errorf("%s %s: got %d %q %q", c.path, c.password, w.Code, w.Body, w.Header().Get("WWW-Authenticate"))
+		}
+	}
+}
@@ -0,0 +1,110 @@
+package sessions
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"errors"
+)
+
+var (
+	ErrInvalidCookie = errors.New("Invalid session cookie")
+	ErrNoKeys        = errors.New("No session signing key")
+)
+
+// Codec signs, and optionally encrypts, the cookie values.
+//
+// The first key of HashKeys signs, and the first key of BlockKeys encrypts;
+// all the keys are tried when decoding. To rotate the keys, put the new ones
+// first and drop the old ones once the cookies they protect have expired.
+type Codec struct {
+	// HashKeys are HMAC-SHA256 keys, 32 bytes or more.
+	HashKeys [][]byte
+	// BlockKeys, if set, are AES keys of 16, 24 or 32 bytes encrypting the
+	// cookies with AES-GCM, so the browser cannot read them.
+	BlockKeys [][]byte
+}
+
+// Encode returns the signed, and encrypted, value of the cookie with the
+// name. The name is signed too, so a cookie cannot be swapped for another.
+func (c *Codec) Encode(name string, value []byte) (string, error) {
+	if len(c.HashKeys) == 0 {
+		return "", ErrNoKeys
+	}
+	if len(c.BlockKeys) > 0 {
+		aead, err := newGCM(c.BlockKeys[0])
+		if err != nil {
+			return "", err
+		}
+		nonce := make([]byte, aead.NonceSize())
+		if _, err := rand.Read(nonce); err != nil {
+			return "", err
+		}
+		value = aead.Seal(nonce, nonce, value, []byte(name))
+	}
+	return base64.RawURLEncoding.EncodeToString(append(value[:len(value):len(value)], sign(c.HashKeys[0], name, value)...)), nil
+}
+
+// Decode checks the cookie value and returns the original value.
+func (c *Codec) Decode(name, cookie string) ([]byte, error) {
+	b, err := base64.RawURLEncoding.DecodeString(cookie)
+	if err != nil || len(b) < sha256.Size {
+		return nil, ErrInvalidCookie
+	}
+	value, mac := b[:len(b)-sha256.Size], b[len(b)-sha256.Size:]
+	signed := false
+	for _, key := range c.HashKeys {
+		if hmac.Equal(sign(key, name, value), mac) {
+			signed = true
+			break
+		}
+	}
+	if !signed {
+		return nil, ErrInvalidCookie
+	}
+	if len(c.BlockKeys) == 0 {
+		return value, nil
+	}
+	for _, key := range c.BlockKeys {
+		aead, err := newGCM(key)
+		if err != nil {
+			return nil, err
+		}
+		if len(value) < aead.NonceSize() {
+			break
+		}
+		nonce, sealed := value[:aead.NonceSize()], value[aead.NonceSize():]
+		if plain, err := aead.Open(nil, nonce, sealed, []byte(name)); err == nil {
+			return plain, nil
+		}
+	}
+	return nil, ErrInvalidCookie
+}
+
+func sign(key []byte, name string, value []byte) []byte {
+	mac := hmac.New(sha256.New, key)
+	mac.Write([]byte(name))
+	mac.Write([]byte{0})
+	mac.Write(value)
+	return mac.Sum(nil)
+}
+
+func newGCM(key []byte) (cipher.AEAD, error) {
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	return cipher.NewGCM(block)
+}
+
+// GenerateKey returns a random key of the length, for Codec.
+func GenerateKey(length int) []byte {
+	key := make([]byte, length)
+	if _, err := rand.Read(key); err != nil {
+		panic(err)
+	}
+	return key
+}
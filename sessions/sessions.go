@@ -0,0 +1,391 @@
+// Package sessions implements cookie sessions. The cookies are signed, and
+// optionally encrypted, by a Codec, and the sessions are kept by a Store:
+// in the cookie itself, in memory or in files.
+//
+// Usage example:
+//
+//	sm := &sessions.Manager{
+//	        Store:  sessions.NewMemoryStore(),
+//	        Codec:  sessions.Codec{HashKeys: [][]byte{hashKey}},
+//	        Secure: true,
+//	}
+//	mux.HandleFunc("/login", func(w http.ResponseWriter, r *http.Request) {
+//	        // check the credentials of the user, then
+//	        sm.Login(r, user.ID)
+//	        http.Redirect(w, r, "/account", http.StatusSeeOther)
+//	})
+//	mux.Handle("/account", handlers.Auth{P: sm.Principal, Next: account, Fallback: toLogin})
+//	http.ListenAndServe(":8080", sm.Handler(mux))
+//
+// The handlers read and update the session with Manager.Get; the
+// Manager.Handler saves it before the response is sent.
+package sessions
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"log"
+	"net/http"
+	"time"
+
+	"github.com/scale-it/go-web/handlers"
+)
+
+var ErrCookieTooLarge = errors.New("Session cookie exceeds 4kB")
+
+const (
+	DefaultCookieName  = "session"
+	DefaultIdleTimeout = 24 * time.Hour
+	DefaultUserKey     = "user"
+
+	// csrfKey is the session value holding the CSRF token.
+	csrfKey = "csrf_token"
+
+	// maxCookieSize is the size of the cookie values accepted by browsers.
+	maxCookieSize = 4000
+)
+
+// Session is the state kept across the requests of a client. The values
+// are stored as JSON, so numbers come back as float64.
+type Session struct {
+	ID      string                 `json:"id"`
+	Values  map[string]interface{} `json:"values,omitempty"`
+	Created time.Time              `json:"created"`
+	Expires time.Time              `json:"expires"`
+
+	isNew     bool
+	modified  bool
+	destroyed bool
+	saved     bool
+	// oldIDs are the IDs replaced by Regenerate, to delete from the store
+	oldIDs []string
+}
+
+func newSession() *Session {
+	return &Session{ID: newID(), Created: time.Now(), isNew: true}
+}
+
+// newID returns a random session ID.
+func newID() string {
+	b := make([]byte, 32)
+	if _, err := rand.Read(b); err != nil {
+		panic(err)
+	}
+	return hex.EncodeToString(b)
+}
+
+// validID checks that the id is one of newID, which is safe in file names.
+func validID(id string) bool {
+	if len(id) != 64 {
+		return false
+	}
+	_, err := hex.DecodeString(id)
+	return err == nil
+}
+
+// IsNew checks if the session was created by this request.
+func (s *Session) IsNew() bool {
+	return s.isNew
+}
+
+func (s *Session) Get(key string) interface{} {
+	return s.Values[key]
+}
+
+func (s *Session) Set(key string, value interface{}) {
+	if s.Values == nil {
+		s.Values = make(map[string]interface{})
+	}
+	s.Values[key] = value
+	s.modified = true
+}
+
+func (s *Session) Delete(key string) {
+	delete(s.Values, key)
+	s.modified = true
+}
+
+// Regenerate gives the session a new ID, keeping its values, and restarts
+// its lifetime. Call it when the privileges change, eg. on login, so an ID
+// known by an attacker before (session fixation) becomes useless.
+func (s *Session) Regenerate() {
+	s.oldIDs = append(s.oldIDs, s.ID)
+	s.ID = newID()
+	s.Created = time.Now()
+	s.modified = true
+}
+
+// Destroy deletes the session, and its cookie, eg. on logout.
+func (s *Session) Destroy() {
+	s.Values = nil
+	s.destroyed = true
+	s.modified = true
+}
+
+// Manager loads and saves the sessions of the requests.
+//
+// The expiry is rolling: every response carrying the session pushes it back
+// by IdleTimeout, up to MaxLifetime after its creation. The new sessions are
+// only saved if they are modified, so anonymous visitors do not fill the
+// store.
+type Manager struct {
+	Store Store
+	Codec Codec
+
+	// CookieName is DefaultCookieName if empty.
+	CookieName string
+	// Path is "/" if empty.
+	Path   string
+	Domain string
+	// Secure sends the cookie over https only. Set it in production.
+	Secure bool
+	// SameSite is http.SameSiteLaxMode if 0.
+	SameSite http.SameSite
+
+	// IdleTimeout is the lifetime of a session without requests,
+	// DefaultIdleTimeout if 0.
+	IdleTimeout time.Duration
+	// MaxLifetime, if set, is the lifetime of a session, however active.
+	MaxLifetime time.Duration
+
+	// UserKey is the session value holding the user ID, set by Login and
+	// read by Principal. DefaultUserKey if empty.
+	UserKey string
+
+	// OnError is called with the errors of the sessions saved by Handler,
+	// which can not be answered anymore. They are logged if nil.
+	OnError func(r *http.Request, err error)
+}
+
+type sessionKey struct{}
+
+// Get returns the session of the request, or a new one. Behind Handler the
+// same session is returned by every call for the request, and it is saved
+// automatically; otherwise it must be saved with Save.
+func (m *Manager) Get(r *http.Request) *Session {
+	if p, ok := r.Context().Value(sessionKey{}).(**Session); ok {
+		if *p == nil {
+			*p = newSession()
+		}
+		return *p
+	}
+	if s := m.load(r); s != nil {
+		return s
+	}
+	return newSession()
+}
+
+// Handler returns a handler loading the session of the requests for next,
+// and saving it before the response headers are written.
+func (m *Manager) Handler(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		s := m.load(r)
+		r = r.WithContext(context.WithValue(r.Context(), sessionKey{}, &s))
+		sw := &saveWriter{ResponseWriter: w}
+		sw.commit = func() {
+			if s == nil || s.saved || (s.isNew && !s.modified) {
+				return
+			}
+			if err := m.Save(w, s); err != nil {
+				if m.OnError != nil {
+					m.OnError(r, err)
+				} else {
+					log.Printf("sessions: %s %s: %v", r.Method, r.URL.Path, err)
+				}
+			}
+		}
+		next.ServeHTTP(sw, r)
+		sw.save()
+	})
+}
+
+// Save stores the session and sets its cookie, pushing back its expiry. A
+// destroyed session is deleted, with its cookie.
+func (m *Manager) Save(w http.ResponseWriter, s *Session) error {
+	for _, id := range s.oldIDs {
+		if err := m.Store.Delete(id); err != nil {
+			return err
+		}
+	}
+	s.oldIDs = nil
+	if s.destroyed {
+		s.saved = true
+		if err := m.Store.Delete(s.ID); err != nil {
+			return err
+		}
+		http.SetCookie(w, m.cookie("", time.Unix(0, 0)))
+		return nil
+	}
+
+	idle := m.IdleTimeout
+	if idle <= 0 {
+		idle = DefaultIdleTimeout
+	}
+	s.Expires = time.Now().Add(idle)
+	if m.MaxLifetime > 0 && s.Created.Add(m.MaxLifetime).Before(s.Expires) {
+		s.Expires = s.Created.Add(m.MaxLifetime)
+	}
+	token, err := m.Store.Save(s)
+	if err != nil {
+		return err
+	}
+	value, err := m.Codec.Encode(m.cookieName(), []byte(token))
+	if err != nil {
+		return err
+	}
+	if len(value) > maxCookieSize {
+		return ErrCookieTooLarge
+	}
+	http.SetCookie(w, m.cookie(value, s.Expires))
+	s.saved, s.modified = true, false
+	return nil
+}
+
+// Login regenerates the session of the request and stores the user ID in
+// it, see UserKey. The CSRF token is deleted, so a new one is made for the
+// user, see CSRFToken.
+func (m *Manager) Login(r *http.Request, userID string) *Session {
+	s := m.Get(r)
+	s.Regenerate()
+	s.Delete(csrfKey)
+	s.Set(m.userKey(), userID)
+	return s
+}
+
+// Logout destroys the session of the request.
+func (m *Manager) Logout(r *http.Request) {
+	m.Get(r).Destroy()
+}
+
+// Principal is a handlers.PrincipalAuthenticator accepting the requests
+// whose session has a user ID, see Login. The principal has the session
+// values as Claims.
+func (m *Manager) Principal(r *http.Request) (*handlers.Principal, bool) {
+	s := m.Get(r)
+	id, ok := s.Get(m.userKey()).(string)
+	if !ok || s.destroyed {
+		return nil, false
+	}
+	claims := make(map[string]interface{}, len(s.Values))
+	for k, v := range s.Values {
+		claims[k] = v
+	}
+	return &handlers.Principal{ID: id, Claims: claims}, true
+}
+
+// Authenticate is a handlers.Authenticator, see Principal.
+func (m *Manager) Authenticate(r *http.Request) bool {
+	_, ok := m.Principal(r)
+	return ok
+}
+
+// CSRFToken returns the CSRF token of the session of the request, creating
+// it if needed. It makes the Manager a handlers.CSRFTokenStore, for the
+// synchronizer token pattern:
+//
+//	sm.Handler(handlers.CSRF{Store: sm, Next: mux})
+func (m *Manager) CSRFToken(r *http.Request) string {
+	s := m.Get(r)
+	token, ok := s.Get(csrfKey).(string)
+	if !ok {
+		token = handlers.NewCSRFToken()
+		s.Set(csrfKey, token)
+	}
+	return token
+}
+
+// load returns the session of the request cookie, or nil.
+func (m *Manager) load(r *http.Request) *Session {
+	c, err := r.Cookie(m.cookieName())
+	if err != nil {
+		return nil
+	}
+	token, err := m.Codec.Decode(m.cookieName(), c.Value)
+	if err != nil {
+		return nil
+	}
+	s, err := m.Store.Load(string(token))
+	if err != nil {
+		return nil
+	}
+	if m.MaxLifetime > 0 && time.Since(s.Created) > m.MaxLifetime {
+		m.Store.Delete(s.ID)
+		return nil
+	}
+	return s
+}
+
+func (m *Manager) cookie(value string, expires time.Time) *http.Cookie {
+	c := &http.Cookie{
+		Name:     m.cookieName(),
+		Value:    value,
+		Path:     m.Path,
+		Domain:   m.Domain,
+		Expires:  expires,
+		Secure:   m.Secure,
+		HttpOnly: true,
+		SameSite: m.SameSite,
+	}
+	if c.Path == "" {
+		c.Path = "/"
+	}
+	if c.SameSite == 0 {
+		c.SameSite = http.SameSiteLaxMode
+	}
+	if value == "" {
+		c.MaxAge = -1
+	}
+	return c
+}
+
+func (m *Manager) cookieName() string {
+	if m.CookieName == "" {
+		return DefaultCookieName
+	}
+	return m.CookieName
+}
+
+func (m *Manager) userKey() string {
+	if m.UserKey == "" {
+		return DefaultUserKey
+	}
+	return m.UserKey
+}
+
+// saveWriter saves the session before the response headers are written.
+type saveWriter struct {
+	http.ResponseWriter
+	commit func()
+	done   bool
+}
+
+func (w *saveWriter) save() {
+	if !w.done {
+		w.done = true
+		w.commit()
+	}
+}
+
+func (w *saveWriter) WriteHeader(code int) {
+	w.save()
+	w.ResponseWriter.WriteHeader(code)
+}
+
+func (w *saveWriter) Write(b []byte) (int, error) {
+	w.save()
+	return w.ResponseWriter.Write(b)
+}
+
+// FlushError saves the session and flushes the ResponseWriter, like
+// http.ResponseController.Flush.
+func (w *saveWriter) FlushError() error {
+	w.save()
+	return http.NewResponseController(w.ResponseWriter).Flush()
+}
+
+// Unwrap returns the wrapped ResponseWriter. It is used by http.ResponseController.
+func (w *saveWriter) Unwrap() http.ResponseWriter {
+	return w.ResponseWriter
+}
@@ -0,0 +1,209 @@
+package sessions
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/scale-it/go-web/handlers"
+)
+
+func TestCodec(t *testing.T) {
+	oldKey, newKey := GenerateKey(32), GenerateKey(32)
+	for _, block := range [][][]byte{nil, {GenerateKey(32)}} {
+		old := Codec{HashKeys: [][]byte{oldKey}, BlockKeys: block}
+		value, err := old.Encode("session", []byte("hello"))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if block != nil && strings.Contains(value, "aGVsbG8") {
+			t.Errorf("value not encrypted: %s", value)
+		}
+
+		rotated := Codec{HashKeys: [][]byte{newKey, oldKey}, BlockKeys: block}
+		if b, err := rotated.Decode("session", value); err != nil || string(b) != "hello" {
+			t.Errorf("rotated keys: got %q, %v", b, err)
+		}
+		if _, err := rotated.Decode("other", value); err != ErrInvalidCookie {
+			t.Errorf("other cookie name: got %v", err)
+		}
+		dropped := Codec{HashKeys: [][]byte{newKey}, BlockKeys: block}
+		if _, err := dropped.Decode("session", value); err != ErrInvalidCookie {
+			t.Errorf("dropped key: got %v", err)
+		}
+		tampered := []byte(value)
+		tampered[0] ^= 1
+		if _, err := old.Decode("session", string(tampered)); err != ErrInvalidCookie {
+			t.Errorf("tampered: got %v", err)
+		}
+	}
+}
+
+// client keeps the cookies of the responses, like a browser.
+type client struct {
+	cookies map[string]*http.Cookie
+}
+
+func (c *client) do(h http.Handler, path string) *httptest.ResponseRecorder {
+	req := httptest.NewRequest("GET", path, nil)
+	for _, cookie := range c.cookies {
+		req.AddCookie(cookie)
+	}
+	w := httptest.NewRecorder()
+	h.ServeHTTP(w, req)
+	for _, cookie := range w.Result().Cookies() {
+		if cookie.MaxAge < 0 {
+			delete(c.cookies, cookie.Name)
+		} else {
+			c.cookies[cookie.Name] = cookie
+		}
+	}
+	return w
+}
+
+func testApp(m *Manager) http.Handler {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/login", func(w http.ResponseWriter, r *http.Request) {
+		m.Login(r, "alice")
+	})
+	mux.HandleFunc("/logout", func(w http.ResponseWriter, r *http.Request) {
+		m.Logout(r)
+	})
+	mux.HandleFunc("/visit", func(w http.ResponseWriter, r *http.Request) {
+		s := m.Get(r)
+		n, _ := s.Get("visits").(float64)
+		s.Set("visits", n+1)
+		fmt.Fprint(w, n+1)
+	})
+	mux.HandleFunc("/id", func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprint(w, m.Get(r).ID)
+	})
+	mux.Handle("/account", handlers.Auth{
+		P: m.Principal,
+		Next: http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			p, _ := handlers.GetPrincipal(r)
+			fmt.Fprint(w, p.ID)
+		}),
+		Fallback: http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			http.Error(w, "login first", http.StatusUnauthorized)
+		}),
+	})
+	return m.Handler(mux)
+}
+
+func TestManager(t *testing.T) {
+	stores := map[string]Store{
+		"cookie": CookieStore{},
+		"memory": NewMemoryStore(),
+		"file":   FileStore{Dir: t.TempDir()},
+	}
+	for name, store := range stores {
+		m := &Manager{Store: store, Codec: Codec{HashKeys: [][]byte{GenerateKey(32)}, BlockKeys: [][]byte{GenerateKey(16)}}}
+		h := testApp(m)
+		c := &client{cookies: map[string]*http.Cookie{}}
+
+		if w := c.do(h, "/id"); len(c.cookies) != 0 {
+			t.Errorf("%s: unmodified new session saved: %v", name, w.Result().Cookies())
+		}
+		for i := 1; i <= 2; i++ {
+			if w := c.do(h, "/visit"); w.Body.String() != fmt.Sprint(i) {
+				t.Errorf("%s: visit %d: got %q", name, i, w.Body)
+			}
+		}
+		if w := c.do(h, "/account"); w.Code != 401 {
+			t.Errorf("%s: anonymous account: got %d", name, w.Code)
+		}
+
+		before := c.do(h, "/id").Body.String()
+		oldCookie := c.cookies[DefaultCookieName]
+		c.do(h, "/login")
+		if after := c.do(h, "/id").Body.String(); after == before {
+			t.Errorf("%s: session ID not regenerated on login", name)
+		}
+		if w := c.do(h, "/account"); w.Code != 200 || w.Body.String() != "alice" {
+			t.Errorf("%s: account: got %d %q", name, w.Code, w.Body)
+		}
+		if w := c.do(h, "/visit"); w.Body.String() != "3" {
+			t.Errorf("%s: values lost on login: got %q", name, w.Body)
+		}
+		if name != "cookie" {
+			stolen := &client{cookies: map[string]*http.Cookie{DefaultCookieName: oldCookie}}
+			if w := stolen.do(h, "/visit"); w.Body.String() != "1" {
+				t.Errorf("%s: old session ID still valid: got %q", name, w.Body)
+			}
+		}
+
+		c.do(h, "/logout")
+		if len(c.cookies) != 0 {
+			t.Errorf("%s: cookie not deleted on logout", name)
+		}
+		if w := c.do(h, "/account"); w.Code != 401 {
+			t.Errorf("%s: account after logout: got %d", name, w.Code)
+		}
+	}
+}
+
+func TestExpiry(t *testing.T) {
+	store := NewMemoryStore()
+	m := &Manager{Store: store, Codec: Codec{HashKeys: [][]byte{GenerateKey(32)}}, IdleTimeout: time.Hour}
+	h := testApp(m)
+	c := &client{cookies: map[string]*http.Cookie{}}
+
+	c.do(h, "/visit")
+	first := c.cookies[DefaultCookieName].Expires
+	time.Sleep(1100 * time.Millisecond)
+	c.do(h, "/id")
+	if rolled := c.cookies[DefaultCookieName].Expires; !rolled.After(first) {
+		t.Errorf("expiry not rolled: %v, then %v", first, rolled)
+	}
+
+	m.MaxLifetime = time.Second
+	if w := c.do(h, "/visit"); w.Body.String() != "1" {
+		t.Errorf("session past its lifetime: got %q", w.Body)
+	}
+	if until := time.Until(c.cookies[DefaultCookieName].Expires); until > time.Second {
+		t.Errorf("expiry beyond the lifetime: %v", until)
+	}
+}
+
+func TestCookieTooLarge(t *testing.T) {
+	m := &Manager{Store: CookieStore{}, Codec: Codec{HashKeys: [][]byte{GenerateKey(32)}}}
+	s := m.Get(httptest.NewRequest("GET", "/", nil))
+	s.Set("data", strings.Repeat("x", 4096))
+	if err := m.Save(httptest.NewRecorder(), s); err != ErrCookieTooLarge {
+		t.Errorf("got %v", err)
+	}
+}
+
+func TestCSRFToken(t *testing.T) {
+	m := &Manager{Store: NewMemoryStore(), Codec: Codec{HashKeys: [][]byte{GenerateKey(32)}}}
+	h := m.Handler(handlers.CSRF{Store: m, Next: http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprint(w, handlers.CSRFToken(r))
+	})})
+	c := &client{cookies: map[string]*http.Cookie{}}
+	anonymous := c.do(h, "/").Body.String()
+	c.do(m.Handler(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		m.Login(r, "alice")
+	})), "/login")
+	token := c.do(h, "/").Body.String()
+	if token == anonymous {
+		t.Errorf("token not rotated on login: %q", token)
+	}
+	if again := c.do(h, "/").Body.String(); token == "" || again != token {
+		t.Fatalf("tokens %q, then %q", token, again)
+	}
+
+	for form, status := range map[string]int{"csrf_token=" + token: 200, "csrf_token=" + anonymous: 403, "csrf_token=other": 403} {
+		req := httptest.NewRequest("POST", "/", strings.NewReader(form))
+		req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+		req.AddCookie(c.cookies[DefaultCookieName])
+		w := httptest.NewRecorder()
+		h.ServeHTTP(w, req)
+		if w.Code != status {
+			t.Errorf("%s: got %d", form, w.Code)
+		}
+	}
+}
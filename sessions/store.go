@@ -0,0 +1,209 @@
+package sessions
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+)
+
+var ErrNotFound = errors.New("Session not found")
+
+// Store keeps the sessions. The cookie holds the token returned by Save:
+// the whole session for CookieStore, or its ID for the server side stores.
+type Store interface {
+	// Save stores the session until s.Expires and returns its token.
+	Save(s *Session) (token string, err error)
+	// Load returns the session of the token, or ErrNotFound if it has none
+	// or it has expired.
+	Load(token string) (*Session, error)
+	// Delete deletes the session with the id.
+	Delete(id string) error
+}
+
+func decodeSession(data []byte) (*Session, error) {
+	s := &Session{}
+	if err := json.Unmarshal(data, s); err != nil {
+		return nil, err
+	}
+	if !time.Now().Before(s.Expires) {
+		return nil, ErrNotFound
+	}
+	return s, nil
+}
+
+// CookieStore keeps the sessions in the cookies, so nothing is stored on
+// the server. The sessions must stay small: browsers reject cookies over
+// 4kB. A deleted session can be brought back by replaying its cookie until
+// it expires; use a server side store if that matters.
+type CookieStore struct{}
+
+func (CookieStore) Save(s *Session) (string, error) {
+	data, err := json.Marshal(s)
+	return string(data), err
+}
+
+func (CookieStore) Load(token string) (*Session, error) {
+	return decodeSession([]byte(token))
+}
+
+func (CookieStore) Delete(id string) error {
+	return nil
+}
+
+// MemoryStore keeps the sessions in memory, which are lost on restart. The
+// expired sessions are removed from time to time. It must be created with
+// NewMemoryStore.
+type MemoryStore struct {
+	mu       sync.Mutex
+	sessions map[string]memoryEntry
+	cleaned  time.Time
+}
+
+type memoryEntry struct {
+	data    []byte
+	expires time.Time
+}
+
+// memoryCleanupInterval is the minimum delay between two removals of the
+// expired sessions of a MemoryStore.
+const memoryCleanupInterval = time.Minute
+
+// NewMemoryStore returns an empty MemoryStore.
+func NewMemoryStore() *MemoryStore {
+	return &MemoryStore{sessions: make(map[string]memoryEntry), cleaned: time.Now()}
+}
+
+func (m *MemoryStore) Save(s *Session) (string, error) {
+	data, err := json.Marshal(s)
+	if err != nil {
+		return "", err
+	}
+	now := time.Now()
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.sessions[s.ID] = memoryEntry{data, s.Expires}
+	if now.Sub(m.cleaned) >= memoryCleanupInterval {
+		for id, e := range m.sessions {
+			if !now.Before(e.expires) {
+				delete(m.sessions, id)
+			}
+		}
+		m.cleaned = now
+	}
+	return s.ID, nil
+}
+
+func (m *MemoryStore) Load(token string) (*Session, error) {
+	m.mu.Lock()
+	e, ok := m.sessions[token]
+	m.mu.Unlock()
+	if !ok {
+		return nil, ErrNotFound
+	}
+	return decodeSession(e.data)
+}
+
+func (m *MemoryStore) Delete(id string) error {
+	m.mu.Lock()
+	delete(m.sessions, id)
+	m.mu.Unlock()
+	return nil
+}
+
+// Len returns the number of stored sessions, including the expired ones
+// not removed yet.
+func (m *MemoryStore) Len() int {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return len(m.sessions)
+}
+
+// FileStore keeps the sessions in files of the directory Dir, one per
+// session, so they survive restarts and may be shared by the processes of
+// a host. Call Cleanup periodically to remove the expired sessions.
+type FileStore struct {
+	Dir string
+}
+
+func (f FileStore) path(id string) (string, bool) {
+	if !validID(id) {
+		return "", false
+	}
+	return filepath.Join(f.Dir, id+".json"), true
+}
+
+func (f FileStore) Save(s *Session) (string, error) {
+	path, ok := f.path(s.ID)
+	if !ok {
+		return "", fmt.Errorf("Invalid session ID %q", s.ID)
+	}
+	data, err := json.Marshal(s)
+	if err != nil {
+		return "", err
+	}
+	tmp, err := os.CreateTemp(f.Dir, ".session-*")
+	if err != nil {
+		return "", err
+	}
+	_, err = tmp.Write(data)
+	if cerr := tmp.Close(); err == nil {
+		err = cerr
+	}
+	if err == nil {
+		err = os.Rename(tmp.Name(), path)
+	}
+	if err != nil {
+		os.Remove(tmp.Name())
+		return "", err
+	}
+	return s.ID, nil
+}
+
+func (f FileStore) Load(token string) (*Session, error) {
+	path, ok := f.path(token)
+	if !ok {
+		return nil, ErrNotFound
+	}
+	data, err := os.ReadFile(path)
+	if os.IsNotExist(err) {
+		return nil, ErrNotFound
+	} else if err != nil {
+		return nil, err
+	}
+	s, err := decodeSession(data)
+	if err == ErrNotFound {
+		os.Remove(path)
+	}
+	return s, err
+}
+
+func (f FileStore) Delete(id string) error {
+	path, ok := f.path(id)
+	if !ok {
+		return nil
+	}
+	if err := os.Remove(path); err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	return nil
+}
+
+// Cleanup removes the expired sessions.
+func (f FileStore) Cleanup() error {
+	entries, err := os.ReadDir(f.Dir)
+	if err != nil {
+		return err
+	}
+	for _, e := range entries {
+		if id, ok := strings.CutSuffix(e.Name(), ".json"); ok && validID(id) {
+			// Load removes the expired session
+			f.Load(id)
+		}
+	}
+	return nil
+}
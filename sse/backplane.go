@@ -0,0 +1,227 @@
+// Copyright 2013 Alexandre Fiori
+// Use of this source code is governed by a BSD-style license that can be
+// found in the LICENSE file.
+
+package sse
+
+import (
+	"bufio"
+	"encoding/json"
+	"net"
+	"sync"
+)
+
+// Backplane distributes the events published on any node (process) to the
+// Brokers of all the nodes, so every browser receives them, whichever node
+// it is connected to.
+type Backplane interface {
+	// Publish sends the event to all the nodes, including this one.
+	Publish(topic string, m *MessageEvent) error
+	// Subscribe registers a function receiving the events published on all
+	// the nodes. Every node receives the events in the same order.
+	Subscribe(deliver func(topic string, m *MessageEvent)) error
+}
+
+// LocalBackplane is an in-process Backplane, connecting the Brokers of a
+// single process. The zero value is ready to use.
+type LocalBackplane struct {
+	mu   sync.Mutex
+	subs []func(topic string, m *MessageEvent)
+}
+
+func (l *LocalBackplane) Publish(topic string, m *MessageEvent) error {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	for _, deliver := range l.subs {
+		deliver(topic, m)
+	}
+	return nil
+}
+
+func (l *LocalBackplane) Subscribe(deliver func(topic string, m *MessageEvent)) error {
+	l.mu.Lock()
+	l.subs = append(l.subs, deliver)
+	l.mu.Unlock()
+	return nil
+}
+
+// backplaneFrame is an event sent over a socket, one JSON document per line.
+type backplaneFrame struct {
+	Topic string
+	Event *MessageEvent
+}
+
+// SocketHub relays the events of the SocketBackplanes connected to it. It
+// sequences the events, so all the nodes receive them in the same order.
+// It is a simple stand-in for a message broker, meant for running several
+// processes on a single machine, usually over a Unix socket.
+type SocketHub struct {
+	l      net.Listener
+	frames chan []byte
+	done   chan struct{}
+	once   sync.Once
+
+	mu    sync.Mutex
+	conns map[net.Conn]struct{}
+}
+
+// ListenHub starts a SocketHub listening on the address,
+// eg. ListenHub("unix", "/tmp/events.sock").
+func ListenHub(network, address string) (*SocketHub, error) {
+	l, err := net.Listen(network, address)
+	if err != nil {
+		return nil, err
+	}
+	h := &SocketHub{
+		l:      l,
+		frames: make(chan []byte),
+		done:   make(chan struct{}),
+		conns:  make(map[net.Conn]struct{}),
+	}
+	go h.accept()
+	go h.relay()
+	return h, nil
+}
+
+// Addr returns the address the hub listens on.
+func (h *SocketHub) Addr() net.Addr {
+	return h.l.Addr()
+}
+
+// Close stops the hub and disconnects all the nodes.
+func (h *SocketHub) Close() error {
+	h.once.Do(func() { close(h.done) })
+	err := h.l.Close()
+	h.mu.Lock()
+	for c := range h.conns {
+		c.Close()
+	}
+	h.mu.Unlock()
+	return err
+}
+
+func (h *SocketHub) accept() {
+	for {
+		c, err := h.l.Accept()
+		if err != nil {
+			return
+		}
+		// the empty line tells the node it receives the events from now on
+		h.mu.Lock()
+		h.conns[c] = struct{}{}
+		_, err = c.Write([]byte("\n"))
+		h.mu.Unlock()
+		if err != nil {
+			h.drop(c)
+			continue
+		}
+		go h.read(c)
+	}
+}
+
+// read passes the frames published by a node to the relay.
+func (h *SocketHub) read(c net.Conn) {
+	r := bufio.NewReader(c)
+	for {
+		frame, err := r.ReadBytes('\n')
+		if err != nil {
+			h.drop(c)
+			return
+		}
+		select {
+		case h.frames <- frame:
+		case <-h.done:
+			return
+		}
+	}
+}
+
+// relay sends every frame, one at a time, to all the nodes.
+func (h *SocketHub) relay() {
+	for {
+		select {
+		case frame := <-h.frames:
+			h.mu.Lock()
+			for c := range h.conns {
+				if _, err := c.Write(frame); err != nil {
+					delete(h.conns, c)
+					c.Close()
+				}
+			}
+			h.mu.Unlock()
+		case <-h.done:
+			return
+		}
+	}
+}
+
+func (h *SocketHub) drop(c net.Conn) {
+	h.mu.Lock()
+	delete(h.conns, c)
+	h.mu.Unlock()
+	c.Close()
+}
+
+// SocketBackplane is a Backplane connected to a SocketHub.
+type SocketBackplane struct {
+	conn net.Conn
+	wmu  sync.Mutex
+	enc  *json.Encoder
+
+	mu   sync.Mutex
+	subs []func(topic string, m *MessageEvent)
+}
+
+// DialBackplane connects to the SocketHub listening on the address.
+// It returns once the hub relays the events to the new node.
+func DialBackplane(network, address string) (*SocketBackplane, error) {
+	c, err := net.Dial(network, address)
+	if err != nil {
+		return nil, err
+	}
+	r := bufio.NewReader(c)
+	if _, err := r.ReadBytes('\n'); err != nil {
+		c.Close()
+		return nil, err
+	}
+	b := &SocketBackplane{conn: c, enc: json.NewEncoder(c)}
+	go b.read(r)
+	return b, nil
+}
+
+func (b *SocketBackplane) Publish(topic string, m *MessageEvent) error {
+	b.wmu.Lock()
+	defer b.wmu.Unlock()
+	return b.enc.Encode(backplaneFrame{topic, m})
+}
+
+func (b *SocketBackplane) Subscribe(deliver func(topic string, m *MessageEvent)) error {
+	b.mu.Lock()
+	b.subs = append(b.subs, deliver)
+	b.mu.Unlock()
+	return nil
+}
+
+// Close disconnects from the hub.
+func (b *SocketBackplane) Close() error {
+	return b.conn.Close()
+}
+
+func (b *SocketBackplane) read(r *bufio.Reader) {
+	dec := json.NewDecoder(r)
+	for {
+		var f backplaneFrame
+		if err := dec.Decode(&f); err != nil {
+			b.conn.Close()
+			return
+		}
+		if f.Event == nil {
+			continue
+		}
+		b.mu.Lock()
+		for _, deliver := range b.subs {
+			deliver(f.Topic, f.Event)
+		}
+		b.mu.Unlock()
+	}
+}
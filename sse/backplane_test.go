@@ -0,0 +1,79 @@
+package sse
+
+import (
+	"path/filepath"
+	"strconv"
+	"sync"
+	"testing"
+)
+
+// testNodes publishes events concurrently from every broker and checks that
+// all the brokers deliver all of them, in the same order.
+func testNodes(t *testing.T, brokers ...*Broker) {
+	const n = 50
+	var subs []*subscriber
+	for _, b := range brokers {
+		b.QueueSize = len(brokers) * n
+		if err := b.Attach(); err != nil {
+			t.Fatal(err)
+		}
+		s, _, _, _ := b.subscribe("news", "")
+		subs = append(subs, s)
+	}
+
+	var wg sync.WaitGroup
+	for i, b := range brokers {
+		wg.Add(1)
+		go func(i int, b *Broker) {
+			defer wg.Done()
+			for j := 0; j < n; j++ {
+				if err := b.Publish("news", &MessageEvent{Id: strconv.Itoa(i*n + j)}); err != nil {
+					t.Error(err)
+				}
+			}
+		}(i, b)
+	}
+	wg.Wait()
+
+	var want []*MessageEvent
+	for i, s := range subs {
+		var got []*MessageEvent
+		for len(got) < len(brokers)*n {
+			<-s.ready
+			got = append(got, s.pop()...)
+		}
+		if i == 0 {
+			want = got
+			continue
+		}
+		for j := range got {
+			if got[j].Id != want[j].Id {
+				t.Fatalf("node %d: event %d is %s, expected %s", i, j, got[j].Id, want[j].Id)
+			}
+		}
+	}
+}
+
+func TestLocalBackplane(t *testing.T) {
+	var bp LocalBackplane
+	testNodes(t, &Broker{Backplane: &bp}, &Broker{Backplane: &bp}, &Broker{Backplane: &bp})
+}
+
+func TestSocketBackplane(t *testing.T) {
+	hub, err := ListenHub("unix", filepath.Join(t.TempDir(), "events.sock"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer hub.Close()
+
+	var brokers []*Broker
+	for i := 0; i < 3; i++ {
+		bp, err := DialBackplane("unix", hub.Addr().String())
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer bp.Close()
+		brokers = append(brokers, &Broker{Backplane: bp})
+	}
+	testNodes(t, brokers...)
+}
@@ -0,0 +1,275 @@
+// Copyright 2013 Alexandre Fiori
+// Use of this source code is governed by a BSD-style license that can be
+// found in the LICENSE file.
+
+package sse
+
+import (
+	"context"
+	"net/http"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// Broker fans out events to all SSE streams subscribed to a topic.
+// The zero value is ready to use. The configuration fields must not be
+// modified once the Broker is in use.
+//
+// With a Backplane, the events published on any node are delivered to the
+// streams of all the nodes.
+//
+// When replay is enabled, the Broker keeps the recent events of every topic
+// and resends the ones a reconnecting browser missed, based on the
+// Last-Event-ID request header and MessageEvent.Id.
+//
+// Usage example:
+//
+//	var broker sse.Broker
+//
+//	func main() {
+//	        http.Handle("/news", broker.Handler("news"))
+//	        go func() {
+//	                for range time.Tick(time.Second) {
+//	                        broker.Publish("news", &sse.MessageEvent{Data: "tick"})
+//	                }
+//	        }()
+//	        http.ListenAndServe(":8080", nil)
+//	}
+type Broker struct {
+	// ReplaySize is the maximum number of events kept per topic for replay.
+	ReplaySize int
+	// ReplayAge is the maximum age of events kept per topic for replay.
+	// Replay is disabled when both ReplaySize and ReplayAge are 0.
+	ReplayAge time.Duration
+	// Stream configures the heartbeats and write timeout of the streams.
+	Stream StreamConfig
+	// QueueSize is the number of events queued for a stream which does not
+	// keep up with the publishers, DefaultQueueSize if 0.
+	QueueSize int
+	// Overflow decides what happens when the queue of a stream is full.
+	Overflow OverflowPolicy
+	// PollTimeout is the time a long-polling request waits for events,
+	// DefaultPollTimeout if 0.
+	PollTimeout time.Duration
+	// Backplane, if set, distributes the published events to all the nodes.
+	// The Broker attaches to it on first use, or when Attach is called.
+	Backplane Backplane
+	// Metrics, if set, receives the activity of the streams, see Stats.
+	Metrics Metrics
+	// Filter, if set, decides which events of the topic the peer may
+	// receive, eg. by tenant or ACL. It is called with the subscribing
+	// request before every event is sent, replayed or polled, from the
+	// goroutine serving the request. The caller authenticated by
+	// handlers.Auth is available through handlers.GetPrincipal(r).
+	// The ResetEvent sent to a peer with an unknown cursor is not filtered.
+	Filter func(r *http.Request, topic string, m *MessageEvent) bool
+
+	mu      sync.Mutex
+	topics  map[string]map[*subscriber]struct{}
+	logs    map[string]*replayLog
+	dropped atomic.Uint64
+
+	attach    sync.Once
+	attachErr error
+}
+
+// Handler returns an http.Handler which subscribes every request to the topic.
+func (b *Broker) Handler(topic string) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if err := b.Subscribe(w, r, topic); err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+		}
+	})
+}
+
+// Subscribe turns the request into an SSE stream and delivers every event
+// published to the topic, until the peer disconnects. Events missed since
+// the Last-Event-ID of the request are replayed first.
+// An error is returned only if the stream can not be established.
+func (b *Broker) Subscribe(w http.ResponseWriter, r *http.Request, topic string) error {
+	created := time.Now()
+	if err := b.Attach(); err != nil {
+		return err
+	}
+	stream, err := b.Stream.NewStream(w, r)
+	if err != nil {
+		return err
+	}
+	defer stream.Close()
+
+	lastId := r.Header.Get("Last-Event-ID")
+	if b.Metrics != nil {
+		b.Metrics.Connected(r, topic, lastId != "")
+		defer b.disconnected(r, topic, created, stream)
+	}
+	s, missed, reset, _ := b.subscribe(topic, lastId)
+	defer b.unsubscribe(topic, s)
+	if reset != nil && b.write(stream, topic, reset) != nil {
+		return nil
+	}
+	for _, m := range missed {
+		if b.send(stream, r, topic, m) != nil {
+			return nil
+		}
+	}
+	for {
+		select {
+		case <-s.ready:
+			for _, m := range s.pop() {
+				if b.send(stream, r, topic, m) != nil {
+					// usually a broken pipe error
+					return nil
+				}
+			}
+		case <-s.kick:
+			return nil
+		case <-stream.Done():
+			return nil
+		}
+	}
+}
+
+// send sends the event to the stream, unless it is filtered out.
+func (b *Broker) send(stream *Stream, r *http.Request, topic string, m *MessageEvent) error {
+	if b.Filter != nil && !b.Filter(r, topic, m) {
+		return nil
+	}
+	return b.write(stream, topic, m)
+}
+
+// write sends the event to the stream, reporting it to the Metrics.
+func (b *Broker) write(stream *Stream, topic string, m *MessageEvent) error {
+	if b.Metrics == nil {
+		return stream.Send(m)
+	}
+	n := stream.BytesWritten()
+	if err := stream.Send(m); err != nil {
+		return err
+	}
+	b.Metrics.Sent(topic, stream.BytesWritten()-n)
+	return nil
+}
+
+// disconnected reports the end of the stream to the Metrics.
+func (b *Broker) disconnected(r *http.Request, topic string, created time.Time, stream *Stream) {
+	// the cause is context.Canceled when the peer disconnects
+	if err := stream.Err(); err != nil && err != context.Canceled {
+		b.Metrics.WriteError(topic, err)
+	}
+	b.Metrics.Disconnected(r, topic, created, stream.EventsSent(), stream.BytesWritten())
+}
+
+// Publish delivers the event to every stream subscribed to the topic,
+// through the Backplane if there is one. The error comes from the Backplane.
+//
+// Publish never waits for the peers: the event is queued for every stream,
+// applying the Overflow policy to the streams which fall behind.
+// Every stream receives the events in the order they are published.
+func (b *Broker) Publish(topic string, m *MessageEvent) error {
+	if b.Backplane == nil {
+		b.deliver(topic, m)
+		return nil
+	}
+	if err := b.Attach(); err != nil {
+		return err
+	}
+	return b.Backplane.Publish(topic, m)
+}
+
+// Attach subscribes the Broker to its Backplane. It is called on first use,
+// but calling it on start ensures the replay log has the events published
+// on other nodes before the first peer connects.
+func (b *Broker) Attach() error {
+	if b.Backplane == nil {
+		return nil
+	}
+	b.attach.Do(func() {
+		b.attachErr = b.Backplane.Subscribe(b.deliver)
+	})
+	return b.attachErr
+}
+
+// deliver queues the event for the streams of this node.
+func (b *Broker) deliver(topic string, m *MessageEvent) {
+	size := b.QueueSize
+	if size <= 0 {
+		size = DefaultQueueSize
+	}
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if l := b.replayLog(topic); l != nil {
+		l.append(m, time.Now())
+	}
+	for s := range b.topics[topic] {
+		if n := s.push(m, size, b.Overflow); n > 0 {
+			b.dropped.Add(uint64(n))
+		}
+	}
+}
+
+// Dropped returns the number of events dropped, by any policy, because
+// streams did not keep up with the publishers.
+func (b *Broker) Dropped() uint64 {
+	return b.dropped.Load()
+}
+
+// Subscribers returns the number of streams subscribed to the topic.
+func (b *Broker) Subscribers(topic string) int {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return len(b.topics[topic])
+}
+
+// subscribe registers a new subscriber for the topic. It returns the events
+// to replay to a peer which has already seen the event with lastId, or the
+// ResetEvent to send if lastId is not in the replay log anymore, and the
+// cursor of the most recent event in the replay log.
+func (b *Broker) subscribe(topic, lastId string) (s *subscriber, missed []*MessageEvent, reset *MessageEvent, head string) {
+	s = newSubscriber()
+	b.mu.Lock()
+	if l := b.replayLog(topic); l != nil {
+		head = l.head()
+		if lastId != "" {
+			var ok bool
+			if missed, ok = l.since(lastId, time.Now()); !ok {
+				reset = l.resetEvent(lastId)
+			}
+		}
+	}
+	if b.topics == nil {
+		b.topics = make(map[string]map[*subscriber]struct{})
+	}
+	if b.topics[topic] == nil {
+		b.topics[topic] = make(map[*subscriber]struct{})
+	}
+	b.topics[topic][s] = struct{}{}
+	b.mu.Unlock()
+	return s, missed, reset, head
+}
+
+func (b *Broker) unsubscribe(topic string, s *subscriber) {
+	b.mu.Lock()
+	delete(b.topics[topic], s)
+	if len(b.topics[topic]) == 0 {
+		delete(b.topics, topic)
+	}
+	b.mu.Unlock()
+}
+
+// replayLog returns the replay log of the topic, or nil if replay is disabled.
+// b.mu must be held.
+func (b *Broker) replayLog(topic string) *replayLog {
+	if b.ReplaySize <= 0 && b.ReplayAge <= 0 {
+		return nil
+	}
+	if b.logs == nil {
+		b.logs = make(map[string]*replayLog)
+	}
+	l := b.logs[topic]
+	if l == nil {
+		l = &replayLog{size: b.ReplaySize, age: b.ReplayAge}
+		b.logs[topic] = l
+	}
+	return l
+}
@@ -0,0 +1,234 @@
+package sse
+
+import (
+	"bufio"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/scale-it/go-web/handlers"
+)
+
+// waitFor polls cond until it holds or the test times out.
+func waitFor(t *testing.T, cond func() bool) {
+	t.Helper()
+	deadline := time.Now().Add(5 * time.Second)
+	for !cond() {
+		if time.Now().After(deadline) {
+			t.Fatal("timed out waiting for condition")
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+}
+
+// readEvent reads lines up to (and excluding) the blank line closing an event.
+func readEvent(t *testing.T, r *bufio.Reader) []string {
+	t.Helper()
+	var lines []string
+	for {
+		l, err := r.ReadString('\n')
+		if err != nil {
+			t.Fatalf("reading event: %v", err)
+		}
+		if l == "\n" {
+			return lines
+		}
+		lines = append(lines, l[:len(l)-1])
+	}
+}
+
+func TestBrokerFanOut(t *testing.T) {
+	var b Broker
+	srv := httptest.NewServer(b.Handler("news"))
+	defer srv.Close()
+
+	var readers []*bufio.Reader
+	for i := 0; i < 3; i++ {
+		resp, err := http.Get(srv.URL)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer resp.Body.Close()
+		if ct := resp.Header.Get("Content-Type"); ct != "text/event-stream" {
+			t.Errorf("expected text/event-stream, got %q", ct)
+		}
+		readers = append(readers, bufio.NewReader(resp.Body))
+	}
+	waitFor(t, func() bool { return b.Subscribers("news") == 3 })
+
+	b.Publish("other", &MessageEvent{Data: "ignored"})
+	b.Publish("news", &MessageEvent{Data: "hello", Id: "1"})
+	for i, r := range readers {
+		got := readEvent(t, r)
+		if len(got) != 2 || got[0] != "id: 1" || got[1] != "data: hello" {
+			t.Errorf("stream %d: unexpected event %q", i, got)
+		}
+	}
+}
+
+func TestBrokerUnsubscribeOnDisconnect(t *testing.T) {
+	var b Broker
+	srv := httptest.NewServer(b.Handler("news"))
+	defer srv.Close()
+
+	resp, err := http.Get(srv.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	waitFor(t, func() bool { return b.Subscribers("news") == 1 })
+	resp.Body.Close()
+	waitFor(t, func() bool { return b.Subscribers("news") == 0 })
+}
+
+func TestBrokerReplay(t *testing.T) {
+	b := Broker{ReplaySize: 2}
+	srv := httptest.NewServer(b.Handler("news"))
+	t.Cleanup(srv.Close) // runs after the response bodies are closed
+	for _, id := range []string{"1", "2", "3"} {
+		b.Publish("news", &MessageEvent{Data: "event " + id, Id: id})
+	}
+
+	get := func(lastId string) *bufio.Reader {
+		req, _ := http.NewRequest("GET", srv.URL, nil)
+		req.Header.Set("Last-Event-ID", lastId)
+		resp, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		t.Cleanup(func() { resp.Body.Close() })
+		return bufio.NewReader(resp.Body)
+	}
+
+	r := get("2")
+	if got := readEvent(t, r); len(got) != 2 || got[1] != "data: event 3" {
+		t.Errorf("expected event 3 to be replayed, got %q", got)
+	}
+
+	// event 1 was evicted from the replay log
+	r = get("1")
+	got := readEvent(t, r)
+	want := []string{"event: reset", "id: 3", "data: 1"}
+	if len(got) != len(want) {
+		t.Fatalf("expected reset event %q, got %q", want, got)
+	}
+	for i := range want {
+		if got[i] != want[i] {
+			t.Errorf("expected reset event %q, got %q", want, got)
+		}
+	}
+
+	// live events follow the replay
+	waitFor(t, func() bool { return b.Subscribers("news") == 2 })
+	b.Publish("news", &MessageEvent{Data: "event 4", Id: "4"})
+	if got := readEvent(t, r); len(got) != 2 || got[1] != "data: event 4" {
+		t.Errorf("expected event 4, got %q", got)
+	}
+}
+
+func TestBrokerReplayEmptyLog(t *testing.T) {
+	b := Broker{ReplaySize: 2}
+	srv := httptest.NewServer(b.Handler("news"))
+	t.Cleanup(srv.Close)
+	get := func(lastId string) *bufio.Reader {
+		req, _ := http.NewRequest("GET", srv.URL, nil)
+		req.Header.Set("Last-Event-ID", lastId)
+		resp, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		t.Cleanup(func() { resp.Body.Close() })
+		return bufio.NewReader(resp.Body)
+	}
+
+	// an id of the events before a restart
+	got := readEvent(t, get("42"))
+	if len(got) != 3 || got[0] != "event: reset" || got[1] != "id: ~0" {
+		t.Fatalf("expected a reset event with an id, got %q", got)
+	}
+
+	// the browser reconnects with the id of the reset
+	r := get("~0")
+	waitFor(t, func() bool { return b.Subscribers("news") == 2 })
+	b.Publish("news", &MessageEvent{Data: "event 1", Id: "1"})
+	if got := readEvent(t, r); len(got) != 2 || got[1] != "data: event 1" {
+		t.Errorf("expected event 1 without reset, got %q", got)
+	}
+}
+
+func TestReplayLogAge(t *testing.T) {
+	l := replayLog{age: time.Minute}
+	now := time.Now()
+	l.append(&MessageEvent{Id: "1"}, now.Add(-2*time.Minute))
+	l.append(&MessageEvent{Id: "2"}, now)
+	if _, ok := l.since("1", now); ok {
+		t.Error("expected event 1 to be evicted")
+	}
+	if events, ok := l.since("2", now); !ok || len(events) != 0 {
+		t.Errorf("expected no events after 2, got %v %v", events, ok)
+	}
+}
+
+func TestBrokerFilter(t *testing.T) {
+	b := Broker{
+		Filter: func(r *http.Request, topic string, m *MessageEvent) bool {
+			p, ok := handlers.GetPrincipal(r)
+			return ok && m.Event == p.ID
+		},
+	}
+	srv := httptest.NewServer(handlers.Auth{
+		P: func(r *http.Request) (*handlers.Principal, bool) {
+			tenant := r.URL.Query().Get("tenant")
+			return &handlers.Principal{ID: tenant}, tenant != ""
+		},
+		Next:     b.Handler("news"),
+		Fallback: http.NotFoundHandler(),
+	})
+	defer srv.Close()
+
+	var readers []*bufio.Reader
+	for _, tenant := range []string{"a", "b"} {
+		resp, err := http.Get(srv.URL + "?tenant=" + tenant)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer resp.Body.Close()
+		readers = append(readers, bufio.NewReader(resp.Body))
+	}
+	waitFor(t, func() bool { return b.Subscribers("news") == 2 })
+
+	b.Publish("news", &MessageEvent{Event: "a", Data: "for a"})
+	b.Publish("news", &MessageEvent{Event: "b", Data: "for b"})
+	for i, want := range []string{"event: a", "event: b"} {
+		if got := readEvent(t, readers[i]); got[0] != want {
+			t.Errorf("stream %d: unexpected event %q", i, got)
+		}
+	}
+}
+
+func TestBrokerFilterReset(t *testing.T) {
+	b := Broker{
+		ReplaySize: 10,
+		Filter:     func(r *http.Request, topic string, m *MessageEvent) bool { return false },
+	}
+	b.Publish("news", &MessageEvent{Data: "hidden", Id: "1"})
+	srv := httptest.NewServer(b.Handler("news"))
+	defer srv.Close()
+	polling := httptest.NewServer(b.PollHandler("news"))
+	defer polling.Close()
+
+	req, _ := http.NewRequest("GET", srv.URL, nil)
+	req.Header.Set("Last-Event-ID", "stale")
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	if got := readEvent(t, bufio.NewReader(resp.Body)); got[0] != "event: reset" {
+		t.Errorf("expected a reset event, got %q", got)
+	}
+
+	if p := poll(t, polling.URL, "stale"); len(p.Events) != 1 || p.Events[0].Event != ResetEvent || p.Cursor != "1" {
+		t.Errorf("expected a reset event, got %+v", p)
+	}
+}
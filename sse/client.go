@@ -0,0 +1,133 @@
+// Copyright 2013 Alexandre Fiori
+// Use of this source code is governed by a BSD-style license that can be
+// found in the LICENSE file.
+
+package sse
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"mime"
+	"net/http"
+	"time"
+)
+
+var ErrUnexpectedResponse = errors.New("Unexpected response to the event stream request")
+
+// DefaultRetry is the reconnection time used by Client when neither
+// Client.Retry nor the stream set it.
+const DefaultRetry = 3 * time.Second
+
+// Client consumes an SSE stream, like the EventSource of the browsers.
+// It reconnects when the stream ends or the connection breaks, waiting for
+// the reconnection time set by the stream, and sends the Last-Event-ID
+// header so the server can resume the stream (see Broker).
+// As in browsers, it does not reconnect if the server responds with a status
+// other than 200 or a content type other than text/event-stream.
+//
+// Usage example:
+//
+//	c := sse.Client{URL: "http://localhost:8080/news"}
+//	err := c.Subscribe(ctx, func(m *sse.MessageEvent) {
+//	        log.Println(m.Event, m.Data)
+//	})
+type Client struct {
+	URL string
+	// HTTPClient makes the requests; http.DefaultClient is used if nil.
+	// It should not have a Timeout, which would cut the streams.
+	HTTPClient *http.Client
+	// Header is added to every request.
+	Header http.Header
+	// Retry is the initial reconnection time, DefaultRetry if 0.
+	Retry time.Duration
+	// LastEventId is sent in the first request.
+	LastEventId string
+}
+
+// Subscribe calls fn for every event received, until ctx is canceled or the
+// stream fails. It returns ctx.Err() once ctx is canceled, or an error
+// wrapping ErrUnexpectedResponse.
+func (c *Client) Subscribe(ctx context.Context, fn func(*MessageEvent)) error {
+	req, err := http.NewRequestWithContext(ctx, "GET", c.URL, nil)
+	if err != nil {
+		return err
+	}
+	for k, v := range c.Header {
+		req.Header[k] = v
+	}
+	req.Header.Set("Accept", "text/event-stream")
+	req.Header.Set("Cache-Control", "no-cache")
+
+	lastId, retry := c.LastEventId, c.Retry
+	if retry <= 0 {
+		retry = DefaultRetry
+	}
+	for {
+		body, err := c.connect(req, lastId)
+		if errors.Is(err, ErrUnexpectedResponse) {
+			return err
+		}
+		// network errors and the end of the stream lead to a reconnection
+		if err == nil {
+			d := NewDecoder(body)
+			for {
+				m, err := d.Decode()
+				if d.Retry() > 0 {
+					retry = time.Duration(d.Retry()) * time.Millisecond
+				}
+				lastId = d.LastEventId()
+				if err != nil {
+					break
+				}
+				fn(m)
+			}
+			body.Close()
+		}
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-time.After(retry):
+		}
+	}
+}
+
+// Events is the channel based variant of Subscribe. The events channel is
+// closed when Subscribe returns, and its error is sent to the errors channel.
+func (c *Client) Events(ctx context.Context) (<-chan *MessageEvent, <-chan error) {
+	events := make(chan *MessageEvent)
+	errc := make(chan error, 1)
+	go func() {
+		errc <- c.Subscribe(ctx, func(m *MessageEvent) {
+			select {
+			case events <- m:
+			case <-ctx.Done():
+			}
+		})
+		close(events)
+	}()
+	return events, errc
+}
+
+// connect requests the stream and returns the response body.
+func (c *Client) connect(req *http.Request, lastId string) (io.ReadCloser, error) {
+	req = req.Clone(req.Context())
+	if lastId != "" {
+		req.Header.Set("Last-Event-ID", lastId)
+	}
+	hc := c.HTTPClient
+	if hc == nil {
+		hc = http.DefaultClient
+	}
+	resp, err := hc.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	ct, _, _ := mime.ParseMediaType(resp.Header.Get("Content-Type"))
+	if resp.StatusCode != http.StatusOK || ct != "text/event-stream" {
+		resp.Body.Close()
+		return nil, fmt.Errorf("%w: %s %q", ErrUnexpectedResponse, resp.Status, ct)
+	}
+	return resp.Body, nil
+}
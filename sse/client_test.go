@@ -0,0 +1,87 @@
+package sse
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestClientReconnect(t *testing.T) {
+	var (
+		mu      sync.Mutex
+		lastIds []string
+	)
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		lastIds = append(lastIds, r.Header.Get("Last-Event-ID"))
+		n := len(lastIds)
+		mu.Unlock()
+		stream, err := NewStream(w, r)
+		if err != nil {
+			t.Error(err)
+			return
+		}
+		// the stream ends after every event, so the client reconnects
+		stream.Send(&MessageEvent{Data: "event", Id: string(rune('0' + n)), Retry: 10})
+	}))
+	defer srv.Close()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	c := Client{URL: srv.URL, LastEventId: "0"}
+	events, errc := c.Events(ctx)
+	for _, want := range []string{"1", "2", "3"} {
+		if m := <-events; m == nil || m.Id != want {
+			t.Fatalf("expected event %s, got %+v", want, m)
+		}
+	}
+	cancel()
+	for range events {
+	}
+	if err := <-errc; err != context.Canceled {
+		t.Errorf("expected context.Canceled, got %v", err)
+	}
+	mu.Lock()
+	defer mu.Unlock()
+	for i, id := range lastIds[:3] {
+		if want := string(rune('0' + i)); id != want {
+			t.Errorf("request %d: expected Last-Event-ID %s, got %s", i, want, id)
+		}
+	}
+}
+
+func TestClientUnexpectedResponse(t *testing.T) {
+	srv := httptest.NewServer(http.NotFoundHandler())
+	defer srv.Close()
+
+	c := Client{URL: srv.URL}
+	err := c.Subscribe(context.Background(), func(*MessageEvent) {})
+	if !errors.Is(err, ErrUnexpectedResponse) {
+		t.Errorf("expected ErrUnexpectedResponse, got %v", err)
+	}
+}
+
+func TestClientBroker(t *testing.T) {
+	b := Broker{ReplaySize: 10}
+	srv := httptest.NewServer(b.Handler("news"))
+	defer srv.Close()
+	b.Publish("news", &MessageEvent{Data: "seen", Id: "1"})
+	b.Publish("news", &MessageEvent{Data: "missed", Id: "2"})
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	c := Client{URL: srv.URL, LastEventId: "1"}
+	events, _ := c.Events(ctx)
+	// the event published before the connection is replayed
+	if m := <-events; m.Data != "missed" {
+		t.Errorf("expected missed event, got %+v", m)
+	}
+	b.Publish("news", &MessageEvent{Data: "live", Id: "3"})
+	if m := <-events; m.Data != "live" {
+		t.Errorf("expected live event, got %+v", m)
+	}
+}
@@ -0,0 +1,66 @@
+// Copyright 2013 Alexandre Fiori
+// Use of this source code is governed by a BSD-style license that can be
+// found in the LICENSE file.
+
+package sse
+
+import (
+	"encoding/base64"
+	"strings"
+
+	"github.com/scale-it/go-web/contentnegotiator"
+)
+
+// NewEvent encodes v with the codec as the Data of an event with the given
+// name (empty for the default "message" event). The codecs are shared with
+// contentnegotiator.Renderer, so REST responses and push events carry the
+// same representation. Binary codecs (eg. msgpack) are base64 encoded, as
+// event data must be text.
+//
+// Usage example:
+//
+//	m, err := sse.NewEvent(contentnegotiator.JSON, "price", Price{"GOOG", 1024})
+//	if err == nil {
+//	        broker.Publish("prices", m)
+//	}
+func NewEvent[T any](c contentnegotiator.Codec, event string, v T) (*MessageEvent, error) {
+	b, err := c.Marshal(v)
+	if err != nil {
+		return nil, err
+	}
+	data := string(b)
+	if !textual(c) {
+		data = base64.StdEncoding.EncodeToString(b)
+	}
+	return &MessageEvent{Data: data, Event: event}, nil
+}
+
+// DecodeEvent decodes the Data of an event created by NewEvent with the same
+// codec, usually on the Client side.
+func DecodeEvent[T any](c contentnegotiator.Codec, m *MessageEvent) (v T, err error) {
+	b := []byte(m.Data)
+	if !textual(c) {
+		if b, err = base64.StdEncoding.DecodeString(m.Data); err != nil {
+			return
+		}
+	}
+	err = c.Unmarshal(b, &v)
+	return
+}
+
+// JSONEvent is NewEvent with the JSON codec.
+func JSONEvent[T any](event string, v T) (*MessageEvent, error) {
+	return NewEvent(contentnegotiator.JSON, event, v)
+}
+
+// DecodeJSON is DecodeEvent with the JSON codec.
+func DecodeJSON[T any](m *MessageEvent) (T, error) {
+	return DecodeEvent[T](contentnegotiator.JSON, m)
+}
+
+// textual checks if the codec produces text which can be sent as is.
+func textual(c contentnegotiator.Codec) bool {
+	ct := c.ContentType()
+	return strings.HasPrefix(ct, "text/") || ct == "application/json" ||
+		strings.HasSuffix(ct, "+json") || ct == "application/xml" || strings.HasSuffix(ct, "+xml")
+}
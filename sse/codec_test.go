@@ -0,0 +1,41 @@
+package sse
+
+import (
+	"bytes"
+	"testing"
+
+	"github.com/scale-it/go-web/contentnegotiator"
+)
+
+type price struct {
+	Symbol string
+	Value  int
+}
+
+func TestEventCodecs(t *testing.T) {
+	want := price{"GOOG", 1024}
+	for _, c := range []contentnegotiator.Codec{contentnegotiator.JSON, contentnegotiator.Msgpack} {
+		m, err := NewEvent(c, "price", want)
+		if err != nil {
+			t.Fatalf("%s: %v", c.ContentType(), err)
+		}
+		// through the wire
+		var buf bytes.Buffer
+		NewEncoder(&buf).Encode(m)
+		if m, err = NewDecoder(&buf).Decode(); err != nil {
+			t.Fatalf("%s: %v", c.ContentType(), err)
+		}
+		got, err := DecodeEvent[price](c, m)
+		if err != nil || got != want || m.Event != "price" {
+			t.Errorf("%s: expected %v, got %v %v", c.ContentType(), want, got, err)
+		}
+	}
+
+	m, _ := JSONEvent("", want)
+	if m.Data != `{"Symbol":"GOOG","Value":1024}` {
+		t.Errorf("unexpected JSON data %s", m.Data)
+	}
+	if got, err := DecodeJSON[price](m); err != nil || got != want {
+		t.Errorf("expected %v, got %v %v", want, got, err)
+	}
+}
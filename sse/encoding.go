@@ -0,0 +1,234 @@
+// Copyright 2013 Alexandre Fiori
+// Use of this source code is governed by a BSD-style license that can be
+// found in the LICENSE file.
+
+package sse
+
+import (
+	"bufio"
+	"bytes"
+	"errors"
+	"io"
+	"strconv"
+	"strings"
+)
+
+// The event stream format is specified at
+// https://html.spec.whatwg.org/multipage/server-sent-events.html#event-stream-interpretation
+
+var ErrInvalidEvent = errors.New("Event name and id must not contain line breaks, id must not contain NUL")
+
+// maxLineSize is the longest line accepted by the Decoder.
+const maxLineSize = 1 << 20
+
+var lineBreaks = strings.NewReplacer("\r\n", "\n", "\r", "\n")
+
+// Encoder writes events in the text/event-stream format.
+//
+// Fields are written in a fixed order: event, id, retry and data. Line
+// breaks in Data (CR, LF or CRLF) are normalized to LF by splitting the data
+// into multiple data lines, so the peer receives it unchanged (modulo line
+// break style). A data field is written whenever Data or Event is set, thus
+// named events with empty Data are still dispatched by browsers, while
+// events carrying only Id or Retry just update the peer's state.
+type Encoder struct {
+	w   io.Writer
+	buf []byte
+}
+
+// NewEncoder returns an Encoder writing to w. Every event is written with a
+// single call to w.Write.
+func NewEncoder(w io.Writer) *Encoder {
+	return &Encoder{w: w}
+}
+
+// Encode writes the event. It returns ErrInvalidEvent, without writing
+// anything, if the event can not be represented in the format.
+func (e *Encoder) Encode(m *MessageEvent) error {
+	if strings.ContainsAny(m.Event, "\r\n") || strings.ContainsAny(m.Id, "\r\n\x00") {
+		return ErrInvalidEvent
+	}
+	b := e.buf[:0]
+	if m.Event != "" {
+		b = appendField(b, "event", m.Event)
+	}
+	if m.Id != "" {
+		b = appendField(b, "id", m.Id)
+	}
+	if m.Retry >= 1 {
+		b = appendField(b, "retry", strconv.Itoa(m.Retry))
+	}
+	if m.Data != "" || m.Event != "" {
+		b = appendLines(b, "data", m.Data)
+	}
+	b = append(b, '\n')
+	e.buf = b
+	_, err := e.w.Write(b)
+	return err
+}
+
+// Comment writes a comment, which is ignored by the peer. Comments are
+// useful to keep an idle connection open.
+func (e *Encoder) Comment(text string) error {
+	e.buf = appendLines(e.buf[:0], "", text)
+	_, err := e.w.Write(e.buf)
+	return err
+}
+
+// appendLines appends a field for every line of the value.
+func appendLines(b []byte, name, value string) []byte {
+	value = lineBreaks.Replace(value)
+	for {
+		i := strings.IndexByte(value, '\n')
+		if i < 0 {
+			return appendField(b, name, value)
+		}
+		b = appendField(b, name, value[:i])
+		value = value[i+1:]
+	}
+}
+
+func appendField(b []byte, name, value string) []byte {
+	b = append(b, name...)
+	b = append(b, ':')
+	if value != "" {
+		b = append(b, ' ')
+		b = append(b, value...)
+	}
+	return append(b, '\n')
+}
+
+// Decoder reads events in the text/event-stream format.
+//
+// It follows the interpretation rules of the specification: events without
+// data are not dispatched, Id is the last event ID seen on the stream, even
+// if it was set by a previous event, and an event not terminated by a blank
+// line when the stream ends is discarded.
+type Decoder struct {
+	s           *bufio.Scanner
+	started     bool
+	lastEventId string
+	retry       int
+}
+
+// NewDecoder returns a Decoder reading from r.
+func NewDecoder(r io.Reader) *Decoder {
+	s := bufio.NewScanner(r)
+	s.Buffer(nil, maxLineSize)
+	s.Split(new(lineSplitter).split)
+	return &Decoder{s: s}
+}
+
+// Decode reads the next event. Retry is set only if the event carried a
+// valid retry field. It returns io.EOF when the stream ends.
+func (d *Decoder) Decode() (*MessageEvent, error) {
+	var (
+		data    strings.Builder
+		event   string
+		hasData bool
+		retry   int
+	)
+	for d.s.Scan() {
+		line := d.s.Text()
+		if !d.started {
+			d.started = true
+			line = strings.TrimPrefix(line, "\ufeff")
+		}
+		if line == "" {
+			if !hasData {
+				event, retry = "", 0
+				continue
+			}
+			return &MessageEvent{
+				Data:  strings.TrimSuffix(data.String(), "\n"),
+				Id:    d.lastEventId,
+				Event: event,
+				Retry: retry,
+			}, nil
+		}
+		if line[0] == ':' {
+			continue
+		}
+		field, value := line, ""
+		if i := strings.IndexByte(line, ':'); i >= 0 {
+			field, value = line[:i], strings.TrimPrefix(line[i+1:], " ")
+		}
+		switch field {
+		case "event":
+			event = value
+		case "data":
+			data.WriteString(value)
+			data.WriteByte('\n')
+			hasData = true
+		case "id":
+			if strings.IndexByte(value, 0) < 0 {
+				d.lastEventId = value
+			}
+		case "retry":
+			if n, ok := parseRetry(value); ok {
+				d.retry, retry = n, n
+			}
+		}
+	}
+	if err := d.s.Err(); err != nil {
+		return nil, err
+	}
+	return nil, io.EOF
+}
+
+// LastEventId returns the last event ID seen on the stream.
+func (d *Decoder) LastEventId() string {
+	return d.lastEventId
+}
+
+// Retry returns the last reconnection time, in milliseconds, set by the
+// stream, or 0.
+func (d *Decoder) Retry() int {
+	return d.retry
+}
+
+// parseRetry accepts only ASCII digits, as the specification requires.
+func parseRetry(s string) (int, bool) {
+	if s == "" {
+		return 0, false
+	}
+	for i := 0; i < len(s); i++ {
+		if s[i] < '0' || s[i] > '9' {
+			return 0, false
+		}
+	}
+	n, err := strconv.Atoi(s)
+	return n, err == nil
+}
+
+// lineSplitter is a bufio.SplitFunc accepting CRLF, LF and CR line endings.
+// A CR at the end of the buffered data ends the line immediately, so the
+// event is not delayed until more data arrives; a LF following it is skipped.
+type lineSplitter struct {
+	skipLF bool
+}
+
+func (l *lineSplitter) split(data []byte, atEOF bool) (int, []byte, error) {
+	if l.skipLF && len(data) > 0 {
+		l.skipLF = false
+		if data[0] == '\n' {
+			return 1, nil, nil
+		}
+	}
+	i := bytes.IndexAny(data, "\r\n")
+	if i < 0 {
+		// an unterminated line at the end of the stream is discarded
+		if atEOF {
+			return len(data), nil, nil
+		}
+		return 0, nil, nil
+	}
+	if data[i] == '\r' {
+		if i+1 == len(data) {
+			l.skipLF = true
+		} else if data[i+1] == '\n' {
+			return i + 2, data[:i], nil
+		}
+	}
+	return i + 1, data[:i], nil
+}
@@ -0,0 +1,164 @@
+package sse
+
+import (
+	"bytes"
+	"io"
+	"strings"
+	"testing"
+)
+
+func encode(t *testing.T, m *MessageEvent) string {
+	t.Helper()
+	var buf bytes.Buffer
+	if err := NewEncoder(&buf).Encode(m); err != nil {
+		t.Fatalf("encoding %+v: %v", m, err)
+	}
+	return buf.String()
+}
+
+func TestEncode(t *testing.T) {
+	tests := []struct {
+		m    MessageEvent
+		want string
+	}{
+		{MessageEvent{Data: "hello"}, "data: hello\n\n"},
+		{MessageEvent{Data: "a\nb\r\nc\rd"}, "data: a\ndata: b\ndata: c\ndata: d\n\n"},
+		{MessageEvent{Data: "trailing\n"}, "data: trailing\ndata:\n\n"},
+		{MessageEvent{Data: " space"}, "data:  space\n\n"},
+		{MessageEvent{Data: "x", Id: "7", Event: "update", Retry: 500},
+			"event: update\nid: 7\nretry: 500\ndata: x\n\n"},
+		{MessageEvent{Event: "ping"}, "event: ping\ndata:\n\n"},
+		{MessageEvent{Retry: 1000}, "retry: 1000\n\n"},
+		{MessageEvent{Id: "42"}, "id: 42\n\n"},
+	}
+	for _, tt := range tests {
+		if got := encode(t, &tt.m); got != tt.want {
+			t.Errorf("%+v: expected %q, got %q", tt.m, tt.want, got)
+		}
+	}
+}
+
+func TestEncodeInvalid(t *testing.T) {
+	for _, m := range []MessageEvent{{Event: "a\nb"}, {Id: "1\r"}, {Id: "1\x00"}} {
+		var buf bytes.Buffer
+		if err := NewEncoder(&buf).Encode(&m); err != ErrInvalidEvent {
+			t.Errorf("%+v: expected ErrInvalidEvent, got %v", m, err)
+		}
+		if buf.Len() != 0 {
+			t.Errorf("%+v: expected nothing to be written, got %q", m, buf.String())
+		}
+	}
+}
+
+func TestEncodeComment(t *testing.T) {
+	var buf bytes.Buffer
+	NewEncoder(&buf).Comment("keep\nalive")
+	if got := buf.String(); got != ": keep\n: alive\n" {
+		t.Errorf("unexpected comment %q", got)
+	}
+}
+
+func decodeAll(t *testing.T, stream string) []*MessageEvent {
+	t.Helper()
+	var events []*MessageEvent
+	d := NewDecoder(strings.NewReader(stream))
+	for {
+		m, err := d.Decode()
+		if err == io.EOF {
+			return events
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+		events = append(events, m)
+	}
+}
+
+// The examples come from the specification.
+func TestDecode(t *testing.T) {
+	tests := []struct {
+		stream string
+		want   []MessageEvent
+	}{
+		{"data: YHOO\ndata: +2\ndata: 10\n\n", []MessageEvent{{Data: "YHOO\n+2\n10"}}},
+		{": test stream\n\ndata: first event\nid: 1\n\ndata:second event\nid\n\ndata:  third event\n",
+			[]MessageEvent{{Data: "first event", Id: "1"}, {Data: "second event"}}},
+		{"data\n\ndata\ndata\n\ndata:", []MessageEvent{{}, {Data: "\n"}}},
+		{"data:test\n\ndata: test\n\n", []MessageEvent{{Data: "test"}, {Data: "test"}}},
+		{"\ufeffevent: add\r\ndata: 73857293\r\rretry: 10\rretry: x\rdata\n\n",
+			[]MessageEvent{{Event: "add", Data: "73857293"}, {Retry: 10}}},
+		{"id: 1\n\ndata: a\n\nid: 2\x00\ndata: b\n\n", []MessageEvent{{Data: "a", Id: "1"}, {Data: "b", Id: "1"}}},
+	}
+	for _, tt := range tests {
+		got := decodeAll(t, tt.stream)
+		if len(got) != len(tt.want) {
+			t.Errorf("%q: expected %d events, got %d", tt.stream, len(tt.want), len(got))
+			continue
+		}
+		for i := range got {
+			if *got[i] != tt.want[i] {
+				t.Errorf("%q: expected %+v, got %+v", tt.stream, tt.want[i], *got[i])
+			}
+		}
+	}
+}
+
+// TestDecodeCR checks that a CR line ending dispatches the event without
+// waiting for the next byte.
+func TestDecodeCR(t *testing.T) {
+	r, w := io.Pipe()
+	go w.Write([]byte("data: x\r\r"))
+	m, err := NewDecoder(r).Decode()
+	if err != nil || m.Data != "x" {
+		t.Errorf("expected event x, got %+v %v", m, err)
+	}
+	w.Close()
+}
+
+func FuzzRoundTrip(f *testing.F) {
+	f.Add("hello", "", "1", 0)
+	f.Add("multi\nline\r\ndata\r", "update", "2", 3000)
+	f.Add("", "ping", "", 0)
+	f.Add("\n\n", "", "", 10)
+	f.Add(" leading: colon", "x:y", " id ", 1)
+	f.Add(":comment like", "data", "data: x", 0)
+	f.Fuzz(func(t *testing.T, data, event, id string, retry int) {
+		m := MessageEvent{Data: data, Event: event, Id: id, Retry: retry}
+		var buf bytes.Buffer
+		err := NewEncoder(&buf).Encode(&m)
+		if strings.ContainsAny(event, "\r\n") || strings.ContainsAny(id, "\r\n\x00") {
+			if err != ErrInvalidEvent {
+				t.Fatalf("%+v: expected ErrInvalidEvent, got %v", m, err)
+			}
+			return
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+		d := NewDecoder(&buf)
+		got, err := d.Decode()
+		if data == "" && event == "" {
+			// nothing to dispatch, only the peer state is updated
+			if err != io.EOF {
+				t.Fatalf("%+v: expected no event, got %+v %v", m, got, err)
+			}
+		} else {
+			if err != nil {
+				t.Fatalf("%+v: %v", m, err)
+			}
+			want := MessageEvent{Data: lineBreaks.Replace(data), Event: event, Id: id}
+			if retry >= 1 {
+				want.Retry = retry
+			}
+			if *got != want {
+				t.Fatalf("expected %+v, got %+v", want, *got)
+			}
+		}
+		if d.LastEventId() != id {
+			t.Errorf("expected last event id %q, got %q", id, d.LastEventId())
+		}
+		if _, err := d.Decode(); err != io.EOF {
+			t.Errorf("%+v: expected a single event, got %v", m, err)
+		}
+	})
+}
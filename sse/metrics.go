@@ -0,0 +1,129 @@
+// Copyright 2013 Alexandre Fiori
+// Use of this source code is governed by a BSD-style license that can be
+// found in the LICENSE file.
+
+package sse
+
+import (
+	"net/http"
+	"sync"
+	"time"
+
+	goweb "github.com/scale-it/go-web"
+)
+
+// Metrics receives the activity of the streams of a Broker. The methods are
+// called concurrently, from the goroutines serving the streams.
+type Metrics interface {
+	// Connected is called when a stream subscribes to the topic.
+	// reconnect is true if the request carries a Last-Event-ID header,
+	// that is the browser reconnects after losing the stream.
+	Connected(r *http.Request, topic string, reconnect bool)
+	// Sent is called after every event sent, with its size in bytes.
+	Sent(topic string, bytes int)
+	// WriteError is called when the stream ends because a write failed.
+	WriteError(topic string, err error)
+	// Disconnected is called when the stream ends, with the time the request
+	// was received, the number of events sent and the bytes written.
+	Disconnected(r *http.Request, topic string, created time.Time, events, bytes int)
+}
+
+// TopicStats are the counters of a topic collected by Stats.
+type TopicStats struct {
+	Connections int           // live streams
+	Connects    uint64        // streams established, including reconnects
+	Reconnects  uint64        // streams established with a Last-Event-ID
+	Events      uint64        // events sent, counting every stream
+	Bytes       uint64        // bytes of the events sent
+	WriteErrors uint64        // streams ended by a write error
+	Duration    time.Duration // total duration of the ended streams
+}
+
+// Stats is a Metrics implementation counting the activity per topic.
+// The zero value is ready to use.
+//
+// If Log is set, every ended stream is logged with goweb.LogRequest, like
+// any other request, with the bytes written and its whole duration.
+//
+// Usage example:
+//
+//	stats := &sse.Stats{Log: func(s string) { log.Println(s) }}
+//	broker := &sse.Broker{Metrics: stats}
+//	...
+//	fmt.Println(stats.Topic("news").Connections)
+type Stats struct {
+	Log func(string)
+
+	mu     sync.Mutex
+	topics map[string]*TopicStats
+}
+
+// topic returns the counters of the topic. s.mu must be held.
+func (s *Stats) topic(topic string) *TopicStats {
+	if s.topics == nil {
+		s.topics = make(map[string]*TopicStats)
+	}
+	t := s.topics[topic]
+	if t == nil {
+		t = &TopicStats{}
+		s.topics[topic] = t
+	}
+	return t
+}
+
+func (s *Stats) Connected(r *http.Request, topic string, reconnect bool) {
+	s.mu.Lock()
+	t := s.topic(topic)
+	t.Connections++
+	t.Connects++
+	if reconnect {
+		t.Reconnects++
+	}
+	s.mu.Unlock()
+}
+
+func (s *Stats) Sent(topic string, bytes int) {
+	s.mu.Lock()
+	t := s.topic(topic)
+	t.Events++
+	t.Bytes += uint64(bytes)
+	s.mu.Unlock()
+}
+
+func (s *Stats) WriteError(topic string, err error) {
+	s.mu.Lock()
+	s.topic(topic).WriteErrors++
+	s.mu.Unlock()
+}
+
+func (s *Stats) Disconnected(r *http.Request, topic string, created time.Time, events, bytes int) {
+	s.mu.Lock()
+	t := s.topic(topic)
+	t.Connections--
+	t.Duration += time.Since(created)
+	s.mu.Unlock()
+	if s.Log != nil {
+		goweb.LogRequest(s.Log, r, created, http.StatusOK, bytes)
+	}
+}
+
+// Topic returns a snapshot of the counters of the topic.
+func (s *Stats) Topic(topic string) TopicStats {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if t := s.topics[topic]; t != nil {
+		return *t
+	}
+	return TopicStats{}
+}
+
+// Topics returns a snapshot of the counters of all the topics.
+func (s *Stats) Topics() map[string]TopicStats {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	m := make(map[string]TopicStats, len(s.topics))
+	for name, t := range s.topics {
+		m[name] = *t
+	}
+	return m
+}
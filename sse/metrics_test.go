@@ -0,0 +1,50 @@
+package sse
+
+import (
+	"bufio"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestStats(t *testing.T) {
+	logged := make(chan string, 1)
+	stats := &Stats{Log: func(s string) { logged <- s }}
+	b := Broker{Metrics: stats}
+	srv := httptest.NewServer(b.Handler("news"))
+	defer srv.Close()
+
+	req, _ := http.NewRequest("GET", srv.URL+"/news", nil)
+	req.Header.Set("Last-Event-ID", "0")
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	waitFor(t, func() bool { return b.Subscribers("news") == 1 })
+	if got := stats.Topic("news"); got.Connections != 1 || got.Connects != 1 || got.Reconnects != 1 {
+		t.Errorf("unexpected stats after connecting %+v", got)
+	}
+
+	b.Publish("news", &MessageEvent{Data: "hello", Id: "1"})
+	b.Publish("news", &MessageEvent{Data: "world", Id: "2"})
+	r := bufio.NewReader(resp.Body)
+	readEvent(t, r)
+	readEvent(t, r)
+	resp.Body.Close()
+	waitFor(t, func() bool { return stats.Topic("news").Connections == 0 })
+
+	// "id: 1\ndata: hello\n\n" and "id: 2\ndata: world\n\n"
+	want := TopicStats{Connects: 1, Reconnects: 1, Events: 2, Bytes: 38}
+	got := stats.Topic("news")
+	if got.Duration <= 0 {
+		t.Errorf("expected a duration, got %v", got.Duration)
+	}
+	got.Duration, got.WriteErrors = 0, 0 // depends on the time the peer left
+	if got != want {
+		t.Errorf("expected %+v, got %+v", want, got)
+	}
+	if l := <-logged; !strings.Contains(l, `"GET /news HTTP/1.1" 200 38B`) {
+		t.Errorf("unexpected log line %q", l)
+	}
+}
@@ -0,0 +1,123 @@
+// Copyright 2013 Alexandre Fiori
+// Use of this source code is governed by a BSD-style license that can be
+// found in the LICENSE file.
+
+package sse
+
+import (
+	"errors"
+	"net/http"
+	"time"
+
+	"github.com/scale-it/go-web/contentnegotiator"
+)
+
+// DefaultPollTimeout is the time a long-polling request waits for events
+// when Broker.PollTimeout is 0. It is below the usual 30s proxy timeout.
+const DefaultPollTimeout = 25 * time.Second
+
+// ErrNoReplay is returned by Poll when the Broker has no replay log, which
+// keeps the events published between two requests.
+var ErrNoReplay = errors.New("Long-polling requires replay, set ReplaySize or ReplayAge")
+
+// PollResponse is the JSON response of a long-polling request. The next
+// request should send Cursor as the lastEventId query parameter.
+type PollResponse struct {
+	Events []*MessageEvent `json:"events"`
+	Cursor string          `json:"cursor"`
+}
+
+// PollHandler returns an http.Handler serving the topic with long-polling,
+// see Poll. It answers 501 Not Implemented if the Broker has no replay.
+func (b *Broker) PollHandler(topic string) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if err := b.Poll(w, r, topic); err == ErrNoReplay {
+			http.Error(w, err.Error(), http.StatusNotImplemented)
+		} else if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+		}
+	})
+}
+
+// Poll serves the topic with long-polling, a fallback for the peers behind
+// proxies which buffer text/event-stream responses. The events published to
+// the Broker are served to both the SSE streams and the polling peers.
+//
+// The peer sends the cursor of the last response as the lastEventId query
+// parameter (or the Last-Event-ID header). The events published since then
+// are returned at once, as a PollResponse; otherwise the request waits up to
+// PollTimeout for new events. The first request, without a cursor, waits for
+// the events published from then on. The cursor is the Id of the last event,
+// or its position after the last event with an Id, so the events without Id
+// are served too; the Ids should then not contain '~'.
+//
+// No event is lost between two requests as long as the replay log keeps
+// them, so replay must be enabled: Poll fails with ErrNoReplay otherwise.
+// The positions of the events without Id are those of the replay log of
+// this node. If the cursor is not in the replay log anymore, a ResetEvent
+// is returned.
+func (b *Broker) Poll(w http.ResponseWriter, r *http.Request, topic string) error {
+	if b.ReplaySize <= 0 && b.ReplayAge <= 0 {
+		return ErrNoReplay
+	}
+	if err := b.Attach(); err != nil {
+		return err
+	}
+	cursor := r.URL.Query().Get("lastEventId")
+	if cursor == "" {
+		cursor = r.Header.Get("Last-Event-ID")
+	}
+	s, events, reset, head := b.subscribe(topic, cursor)
+	if reset != nil {
+		// the reset is not filtered, the peer must learn its cursor is lost
+		b.unsubscribe(topic, s)
+		return writePoll(w, PollResponse{[]*MessageEvent{reset}, reset.Id})
+	}
+	if cursor == "" {
+		// the peer starts from the most recent event
+		cursor = head
+	}
+	if len(events) == 0 {
+		b.wait(r, s)
+		events = s.pop()
+	}
+	b.unsubscribe(topic, s)
+	// the cursor moves past the filtered events too
+	allowed := []*MessageEvent{}
+	for _, m := range events {
+		cursor = nextCursor(cursor, m)
+		if b.Filter == nil || b.Filter(r, topic, m) {
+			allowed = append(allowed, m)
+		}
+	}
+	return writePoll(w, PollResponse{allowed, cursor})
+}
+
+// writePoll writes the response of a long-polling request.
+func writePoll(w http.ResponseWriter, p PollResponse) error {
+	content, err := contentnegotiator.JSON.Marshal(p)
+	if err != nil {
+		return err
+	}
+	w.Header().Set("Cache-Control", "no-cache")
+	w.Header().Set("Content-Type", contentnegotiator.JSON.ContentType())
+	_, err = w.Write(content)
+	return err
+}
+
+// wait blocks until events are queued for the subscriber, the poll times
+// out or the peer disconnects.
+func (b *Broker) wait(r *http.Request, s *subscriber) {
+	timeout := b.PollTimeout
+	if timeout <= 0 {
+		timeout = DefaultPollTimeout
+	}
+	t := time.NewTimer(timeout)
+	defer t.Stop()
+	select {
+	case <-s.ready:
+	case <-s.kick:
+	case <-t.C:
+	case <-r.Context().Done():
+	}
+}
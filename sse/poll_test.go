@@ -0,0 +1,138 @@
+package sse
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func poll(t *testing.T, url, cursor string) PollResponse {
+	t.Helper()
+	resp, err := http.Get(url + "?lastEventId=" + cursor)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	var p PollResponse
+	if err := json.NewDecoder(resp.Body).Decode(&p); err != nil {
+		t.Fatal(err)
+	}
+	return p
+}
+
+func TestPoll(t *testing.T) {
+	b := Broker{ReplaySize: 10, PollTimeout: 200 * time.Millisecond}
+	srv := httptest.NewServer(b.PollHandler("news"))
+	defer srv.Close()
+
+	b.Publish("news", &MessageEvent{Data: "old", Id: "1"})
+	// the first request waits for new events
+	p := poll(t, srv.URL, "")
+	if len(p.Events) != 0 || p.Cursor != "1" {
+		t.Fatalf("expected cursor 1 and no events, got %+v", p)
+	}
+
+	// nothing new: the request times out
+	if p = poll(t, srv.URL, "1"); len(p.Events) != 0 || p.Cursor != "1" {
+		t.Fatalf("expected no events, got %+v", p)
+	}
+
+	// published while the peer was not polling
+	b.Publish("news", &MessageEvent{Data: "a", Id: "2"})
+	b.Publish("news", &MessageEvent{Data: "b", Id: "3"})
+	if p = poll(t, srv.URL, "1"); len(p.Events) != 2 || p.Cursor != "3" || p.Events[1].Data != "b" {
+		t.Fatalf("expected events 2 and 3, got %+v", p)
+	}
+
+	// published while the peer waits
+	go func() {
+		waitFor(t, func() bool { return b.Subscribers("news") == 1 })
+		b.Publish("news", &MessageEvent{Data: "c", Id: "4"})
+	}()
+	if p = poll(t, srv.URL, "3"); len(p.Events) != 1 || p.Cursor != "4" || p.Events[0].Data != "c" {
+		t.Fatalf("expected event 4, got %+v", p)
+	}
+
+	if p = poll(t, srv.URL, "unknown"); len(p.Events) != 1 || p.Events[0].Event != ResetEvent {
+		t.Fatalf("expected a reset event, got %+v", p)
+	}
+}
+
+func TestPollEmptyTopic(t *testing.T) {
+	b := Broker{ReplaySize: 10, PollTimeout: 200 * time.Millisecond}
+	srv := httptest.NewServer(b.PollHandler("news"))
+	defer srv.Close()
+
+	start := time.Now()
+	p := poll(t, srv.URL, "")
+	if len(p.Events) != 0 || p.Cursor != "~0" {
+		t.Errorf("expected no events, got %+v", p)
+	}
+	if elapsed := time.Since(start); elapsed < 200*time.Millisecond {
+		t.Errorf("the request returned after %v, without waiting", elapsed)
+	}
+
+	go func() {
+		waitFor(t, func() bool { return b.Subscribers("news") == 1 })
+		b.Publish("news", &MessageEvent{Data: "tick"})
+	}()
+	if p = poll(t, srv.URL, p.Cursor); len(p.Events) != 1 || p.Events[0].Data != "tick" || p.Cursor != "~1" {
+		t.Errorf("expected the tick, got %+v", p)
+	}
+
+	// published between two requests
+	b.Publish("news", &MessageEvent{Data: "tock"})
+	if p = poll(t, srv.URL, p.Cursor); len(p.Events) != 1 || p.Events[0].Data != "tock" || p.Cursor != "~2" {
+		t.Errorf("expected the tock, got %+v", p)
+	}
+}
+
+func TestPollWithoutReplay(t *testing.T) {
+	var b Broker
+	srv := httptest.NewServer(b.PollHandler("news"))
+	defer srv.Close()
+
+	b.Publish("news", &MessageEvent{Data: "lost", Id: "1"})
+	resp, err := http.Get(srv.URL + "?lastEventId=1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusNotImplemented {
+		t.Errorf("expected 501, got %d", resp.StatusCode)
+	}
+}
+
+func TestPollWithoutIds(t *testing.T) {
+	b := Broker{ReplaySize: 3, PollTimeout: 200 * time.Millisecond}
+	srv := httptest.NewServer(b.PollHandler("news"))
+	defer srv.Close()
+
+	b.Publish("news", &MessageEvent{Data: "a"})
+	b.Publish("news", &MessageEvent{Data: "b"})
+	if p := poll(t, srv.URL, "~1"); len(p.Events) != 1 || p.Events[0].Data != "b" || p.Cursor != "~2" {
+		t.Fatalf("expected b, got %+v", p)
+	}
+	// no event is served twice
+	if p := poll(t, srv.URL, "~2"); len(p.Events) != 0 || p.Cursor != "~2" {
+		t.Fatalf("expected no events, got %+v", p)
+	}
+
+	b.Publish("news", &MessageEvent{Data: "c", Id: "3"})
+	b.Publish("news", &MessageEvent{Data: "d"})
+	p := poll(t, srv.URL, "~2")
+	if len(p.Events) != 2 || p.Events[1].Data != "d" || p.Cursor != "3~1" {
+		t.Fatalf("expected c and d, got %+v", p)
+	}
+	b.Publish("news", &MessageEvent{Data: "e"})
+	if p = poll(t, srv.URL, p.Cursor); len(p.Events) != 1 || p.Events[0].Data != "e" || p.Cursor != "3~2" {
+		t.Fatalf("expected e, got %+v", p)
+	}
+
+	// a and b were evicted
+	if p = poll(t, srv.URL, "~1"); len(p.Events) != 1 || p.Events[0].Event != ResetEvent || p.Cursor != "3~2" {
+		t.Fatalf("expected a reset event, got %+v", p)
+	}
+}
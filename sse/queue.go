@@ -0,0 +1,104 @@
+// Copyright 2013 Alexandre Fiori
+// Use of this source code is governed by a BSD-style license that can be
+// found in the LICENSE file.
+
+package sse
+
+import "sync"
+
+// OverflowPolicy decides what happens when a new event is published to a
+// stream whose queue is full, because the peer does not read fast enough.
+type OverflowPolicy int
+
+const (
+	// DropOldest drops the oldest queued event.
+	DropOldest OverflowPolicy = iota
+	// DropNewest drops the new event.
+	DropNewest
+	// Coalesce replaces the queued event with the same name (MessageEvent.Event)
+	// by the new one, or drops the oldest event if there is none. It suits
+	// events carrying the full state of something, where only the latest
+	// one matters.
+	Coalesce
+	// Disconnect closes the stream. The peer reconnects and catches up with
+	// the replay log, if any.
+	Disconnect
+)
+
+// DefaultQueueSize is the number of events queued per stream when
+// Broker.QueueSize is 0.
+const DefaultQueueSize = 16
+
+// subscriber is a single stream registered in the Broker. Publishers add
+// events to its bounded queue without waiting for the peer.
+type subscriber struct {
+	mu     sync.Mutex
+	queue  []*MessageEvent
+	kicked bool
+
+	ready chan struct{} // signaled when events are queued
+	kick  chan struct{} // closed when the stream must be disconnected
+}
+
+func newSubscriber() *subscriber {
+	return &subscriber{
+		ready: make(chan struct{}, 1),
+		kick:  make(chan struct{}),
+	}
+}
+
+// push queues the event, applying the policy if the queue has size events
+// already. It returns the number of events dropped.
+func (s *subscriber) push(m *MessageEvent, size int, policy OverflowPolicy) (dropped int) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.kicked {
+		return 1
+	}
+	if len(s.queue) >= size {
+		switch policy {
+		case DropNewest:
+			return 1
+		case Disconnect:
+			dropped = len(s.queue) + 1
+			s.queue, s.kicked = nil, true
+			close(s.kick)
+			return dropped
+		case Coalesce:
+			s.removeFirst(func(q *MessageEvent) bool { return q.Event == m.Event })
+		default:
+			s.removeFirst(func(*MessageEvent) bool { return true })
+		}
+		dropped = 1
+	}
+	s.queue = append(s.queue, m)
+	select {
+	case s.ready <- struct{}{}:
+	default:
+	}
+	return dropped
+}
+
+// removeFirst removes the oldest queued event matching f, or the oldest one
+// if none matches. s.mu must be held.
+func (s *subscriber) removeFirst(f func(*MessageEvent) bool) {
+	i := 0
+	for j, q := range s.queue {
+		if f(q) {
+			i = j
+			break
+		}
+	}
+	copy(s.queue[i:], s.queue[i+1:])
+	s.queue[len(s.queue)-1] = nil
+	s.queue = s.queue[:len(s.queue)-1]
+}
+
+// pop takes all the queued events.
+func (s *subscriber) pop() []*MessageEvent {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	q := s.queue
+	s.queue = nil
+	return q
+}
@@ -0,0 +1,65 @@
+package sse
+
+import "testing"
+
+func TestOverflowPolicies(t *testing.T) {
+	events := []*MessageEvent{
+		{Event: "price", Data: "1"},
+		{Event: "news", Data: "2"},
+		{Event: "price", Data: "3"},
+		{Event: "chat", Data: "4"},
+	}
+	tests := []struct {
+		policy  OverflowPolicy
+		want    string
+		dropped int
+	}{
+		{DropOldest, "34", 2},
+		{DropNewest, "12", 2},
+		{Coalesce, "34", 2}, // 3 replaces 1, then 4 drops the oldest: 2
+		{Disconnect, "", 4},
+	}
+	for _, tt := range tests {
+		s := newSubscriber()
+		dropped := 0
+		for _, m := range events {
+			dropped += s.push(m, 2, tt.policy)
+		}
+		got := ""
+		for _, m := range s.pop() {
+			got += m.Data
+		}
+		if got != tt.want || dropped != tt.dropped {
+			t.Errorf("policy %d: expected %q with %d dropped, got %q with %d dropped",
+				tt.policy, tt.want, tt.dropped, got, dropped)
+		}
+	}
+
+	s := newSubscriber()
+	for _, m := range events[:3] {
+		s.push(m, 3, Coalesce)
+	}
+	s.push(&MessageEvent{Event: "price", Data: "5"}, 3, Coalesce)
+	got := ""
+	for _, m := range s.pop() {
+		got += m.Data
+	}
+	if got != "235" {
+		t.Errorf("expected the oldest price to be coalesced, got %q", got)
+	}
+}
+
+func TestBrokerDisconnectSlowStream(t *testing.T) {
+	b := Broker{QueueSize: 1, Overflow: Disconnect}
+	s, _, _, _ := b.subscribe("news", "")
+	b.Publish("news", &MessageEvent{Data: "1"})
+	b.Publish("news", &MessageEvent{Data: "2"})
+	select {
+	case <-s.kick:
+	default:
+		t.Error("expected the stream to be disconnected")
+	}
+	if b.Dropped() != 2 {
+		t.Errorf("expected 2 dropped events, got %d", b.Dropped())
+	}
+}
@@ -0,0 +1,166 @@
+// Copyright 2013 Alexandre Fiori
+// Use of this source code is governed by a BSD-style license that can be
+// found in the LICENSE file.
+
+package sse
+
+import (
+	"strconv"
+	"strings"
+	"time"
+)
+
+// ResetEvent is the name of the event sent instead of the missed events when
+// the Last-Event-ID of a reconnecting peer is no longer in the replay log.
+// Its Data holds the requested ID and its Id the most recent ID known to the
+// Broker, so the peer should reload its state and continue from there. The
+// Id is never empty, so browsers replace their Last-Event-ID: when the
+// replay log is empty, eg. after a restart, it is the position "~<n>" of
+// the n-th event of the topic, "~0" before the first one.
+const ResetEvent = "reset"
+
+// replayLog is the bounded history of the events published to a topic.
+type replayLog struct {
+	size    int           // maximum number of events, 0 for no limit
+	age     time.Duration // maximum age of events, 0 for no limit
+	entries []replayEntry
+	evicted int // number of events evicted since the log was created
+}
+
+type replayEntry struct {
+	m  *MessageEvent
+	at time.Time
+}
+
+func (l *replayLog) append(m *MessageEvent, now time.Time) {
+	l.entries = append(l.entries, replayEntry{m, now})
+	l.trim(now)
+}
+
+// trim evicts events exceeding the size and age limits.
+func (l *replayLog) trim(now time.Time) {
+	i := 0
+	if l.size > 0 && len(l.entries) > l.size {
+		i = len(l.entries) - l.size
+	}
+	if l.age > 0 {
+		for i < len(l.entries) && now.Sub(l.entries[i].at) > l.age {
+			i++
+		}
+	}
+	if i > 0 {
+		l.evicted += i
+		n := copy(l.entries, l.entries[i:])
+		for j := n; j < len(l.entries); j++ {
+			l.entries[j] = replayEntry{}
+		}
+		l.entries = l.entries[:n]
+	}
+}
+
+// since returns the events published after the event of the cursor, an
+// event id or a position, see cursorAt. ok is false if the cursor is not in
+// the log anymore.
+func (l *replayLog) since(cursor string, now time.Time) (events []*MessageEvent, ok bool) {
+	l.trim(now)
+	i, ok := l.find(cursor)
+	if !ok {
+		return nil, false
+	}
+	for _, e := range l.entries[i+1:] {
+		events = append(events, e.m)
+	}
+	return events, true
+}
+
+// find returns the index of the event of the cursor, -1 for the position
+// before the first event.
+func (l *replayLog) find(cursor string) (int, bool) {
+	if i := l.index(cursor); i >= 0 {
+		return i, true
+	}
+	id, n, ok := splitCursor(cursor)
+	if !ok {
+		return 0, false
+	}
+	var i int
+	if id == "" {
+		// the n-th event published to the topic
+		i = n - 1 - l.evicted
+	} else if i = l.index(id); i < 0 {
+		return 0, false
+	} else {
+		i += n
+	}
+	if i < -1 || i >= len(l.entries) {
+		return 0, false
+	}
+	return i, true
+}
+
+// index returns the index of the most recent event with the id, or -1.
+func (l *replayLog) index(id string) int {
+	for i := len(l.entries) - 1; i >= 0; i-- {
+		if l.entries[i].m.Id == id {
+			return i
+		}
+	}
+	return -1
+}
+
+// cursorAt returns the cursor of the event at index i, or of the position
+// before the first event if i is -1. It is the id of the event, or, for an
+// event without id, "<id>~<n>": the n-th event after the event with the id,
+// or after the start of the topic if there is none ("~0" before the first
+// event).
+func (l *replayLog) cursorAt(i int) string {
+	n := 0
+	for ; i >= 0; i, n = i-1, n+1 {
+		if id := l.entries[i].m.Id; id != "" {
+			return joinCursor(id, n)
+		}
+	}
+	return joinCursor("", n+l.evicted)
+}
+
+// head returns the cursor of the most recent event in the log.
+func (l *replayLog) head() string {
+	return l.cursorAt(len(l.entries) - 1)
+}
+
+// resetEvent builds the ResetEvent for a peer requesting the given cursor.
+func (l *replayLog) resetEvent(cursor string) *MessageEvent {
+	return &MessageEvent{Event: ResetEvent, Data: cursor, Id: l.head()}
+}
+
+// nextCursor returns the cursor following the event delivered after the
+// cursor: the id of the event, or the position after the cursor.
+func nextCursor(cursor string, m *MessageEvent) string {
+	if m.Id != "" {
+		return m.Id
+	}
+	id, n, ok := splitCursor(cursor)
+	if !ok {
+		id, n = cursor, 0
+	}
+	return joinCursor(id, n+1)
+}
+
+func joinCursor(id string, n int) string {
+	if n == 0 && id != "" {
+		return id
+	}
+	return id + "~" + strconv.Itoa(n)
+}
+
+func splitCursor(cursor string) (id string, n int, ok bool) {
+	i := strings.LastIndexByte(cursor, '~')
+	if i < 0 {
+		return "", 0, false
+	}
+	n, err := strconv.Atoi(cursor[i+1:])
+	if err != nil || n < 0 {
+		return "", 0, false
+	}
+	return cursor[:i], n, true
+}
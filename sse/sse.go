@@ -8,17 +8,21 @@
 // Usage example:
 //
 //	func SSEHandler(w http.ResponseWriter, req *http.Request) {
-//	        conn, err := sse.ServeEvents(w)
+//	        stream, err := sse.NewStream(w, req)
 //	        if err != nil {
 //	                http.Error(w, err.Error(), http.StatusInternalServerError)
 //	                return
 //	        }
-//	        defer conn.Close()
 //	        for i := 0; i < 10; i++ {
-//	                sse.SendEvent(conn, &sse.MessageEvent{Data: "Hello, world"})
+//	                if stream.Send(&sse.MessageEvent{Data: "Hello, world"}) != nil {
+//	                        return
+//	                }
 //	                time.Sleep(1 * time.Second)
 //	        }
 //	}
+//
+// ServeEvents and SendEvent are the lower level, HTTP/1.x only alternative,
+// which hijacks the connection.
 package sse
 
 import (
@@ -33,14 +37,15 @@ var ErrNoHijack = errors.New("Server does not support hijacking")
 
 // MessageEvent is the container of Server-Sent events (SSE), push notifications.
 type MessageEvent struct {
-	Data  string // message content
-	Id    string // id of the message (int?)
-	Event string // name of the event
-	Retry int    // client reconnection time, in milliseconds
+	Data  string `json:"data,omitempty"`  // message content
+	Id    string `json:"id,omitempty"`    // id of the message (int?)
+	Event string `json:"event,omitempty"` // name of the event
+	Retry int    `json:"retry,omitempty"` // client reconnection time, in milliseconds
 }
 
 // ServeEvents prepares the request for SSE, push notifications.
 // Caveat: ResponseWriter.Status() returns 0 after ServeEvents is called.
+// It requires http.Hijacker, so it fails over HTTP/2; use NewStream instead.
 func ServeEvents(w http.ResponseWriter) (net.Conn, *bufio.ReadWriter, error) {
 	w.Header().Set("Cache-Control", "no-cache")
 	w.Header().Set("Connection", "keep-alive")
@@ -60,19 +65,7 @@ func ServeEvents(w http.ResponseWriter) (net.Conn, *bufio.ReadWriter, error) {
 // Browsers can handle these events in JavaScript:
 // http://www.w3schools.com/html/html5_serversentevents.asp
 func SendEvent(buf *bufio.ReadWriter, m *MessageEvent) (err error) {
-	if m.Data != "" {
-		fmt.Fprintf(buf, "data: %s\n", m.Data)
-	}
-	if m.Event != "" {
-		fmt.Fprintf(buf, "event: %s\n", m.Event)
-	}
-	if m.Id != "" {
-		fmt.Fprintf(buf, "id: %s\n", m.Id)
-	}
-	if m.Retry >= 1 {
-		fmt.Fprintf(buf, "retry: %d\n", m.Retry)
-	}
-	_, err = fmt.Fprintf(buf, "\n")
+	err = NewEncoder(buf).Encode(m)
 	if err == nil {
 		err = buf.Flush()
 	}
@@ -0,0 +1,221 @@
+// Copyright 2013 Alexandre Fiori
+// Use of this source code is governed by a BSD-style license that can be
+// found in the LICENSE file.
+
+package sse
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"net/http"
+	"sync"
+	"time"
+)
+
+var ErrNoFlush = errors.New("ResponseWriter does not support flushing")
+
+// StreamConfig configures the keep-alive of a Stream.
+type StreamConfig struct {
+	// Heartbeat is the interval of the comment lines sent on an idle stream,
+	// so proxies and load balancers do not close it. 0 disables heartbeats.
+	Heartbeat time.Duration
+	// WriteTimeout is the deadline of every write. The stream is closed when
+	// the peer does not read in time. 0 means no deadline.
+	WriteTimeout time.Duration
+}
+
+// Stream is an SSE connection written through the http.ResponseWriter and
+// flushed after every event. Unlike ServeEvents it works over HTTP/2 and
+// through middlewares wrapping the ResponseWriter, such as handlers.Gzip or
+// handlers.WrapWriter, so the status and bytes written are accounted for.
+// Stream is safe for concurrent use.
+//
+// The stream is done when the peer disconnects, a write fails or Close is
+// called. Producers should select on Done to stop sending.
+type Stream struct {
+	ctx     context.Context
+	cancel  context.CancelCauseFunc
+	w       http.ResponseWriter
+	rc      *http.ResponseController
+	timeout time.Duration
+	stopped chan struct{} // closed when the heartbeat goroutine exits
+
+	mu        sync.Mutex
+	buf       bytes.Buffer
+	enc       *Encoder
+	lastWrite time.Time
+	events    int
+	bytes     int
+}
+
+// NewStream prepares the request for SSE and sends the response headers.
+// The stream has no heartbeat nor write deadline, see StreamConfig.NewStream.
+func NewStream(w http.ResponseWriter, req *http.Request) (*Stream, error) {
+	return StreamConfig{}.NewStream(w, req)
+}
+
+// NewStream prepares the request for SSE and sends the response headers.
+// It returns ErrNoFlush if neither w nor any ResponseWriter it wraps
+// implements http.Flusher.
+// When Heartbeat or WriteTimeout is set, Close must be called before the
+// handler returns.
+func (c StreamConfig) NewStream(w http.ResponseWriter, req *http.Request) (*Stream, error) {
+	if !canFlush(w) {
+		return nil, ErrNoFlush
+	}
+	w.Header().Set("Cache-Control", "no-cache")
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.WriteHeader(http.StatusOK)
+	s := &Stream{
+		w:       w,
+		rc:      http.NewResponseController(w),
+		timeout: c.WriteTimeout,
+		stopped: make(chan struct{}),
+	}
+	s.ctx, s.cancel = context.WithCancelCause(req.Context())
+	s.enc = NewEncoder(&s.buf)
+	s.mu.Lock()
+	err := s.flush()
+	s.mu.Unlock()
+	if err != nil {
+		return nil, err
+	}
+	if c.Heartbeat > 0 {
+		go s.heartbeat(c.Heartbeat)
+	} else {
+		close(s.stopped)
+	}
+	return s, nil
+}
+
+// Context returns the context of the stream, which is canceled when the
+// stream is done.
+func (s *Stream) Context() context.Context {
+	return s.ctx
+}
+
+// Done returns a channel which is closed when the stream is done.
+func (s *Stream) Done() <-chan struct{} {
+	return s.ctx.Done()
+}
+
+// Err returns the reason the stream is done, or nil.
+func (s *Stream) Err() error {
+	return context.Cause(s.ctx)
+}
+
+// Close marks the stream as done, stops the heartbeats and clears the write
+// deadline. It does not close the connection, which is released when the
+// handler returns.
+func (s *Stream) Close() error {
+	s.cancel(context.Canceled)
+	<-s.stopped
+	if s.timeout > 0 {
+		s.mu.Lock()
+		s.rc.SetWriteDeadline(time.Time{})
+		s.mu.Unlock()
+	}
+	return nil
+}
+
+// Send writes the event to the peer and flushes it.
+func (s *Stream) Send(m *MessageEvent) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.buf.Reset()
+	if err := s.enc.Encode(m); err != nil {
+		return err
+	}
+	if err := s.flush(); err != nil {
+		return err
+	}
+	s.events++
+	return nil
+}
+
+// EventsSent returns the number of events sent.
+func (s *Stream) EventsSent() int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.events
+}
+
+// BytesWritten returns the number of bytes written, including the comments.
+func (s *Stream) BytesWritten() int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.bytes
+}
+
+// Comment writes a comment line, which is ignored by the peer, and flushes it.
+func (s *Stream) Comment(text string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.buf.Reset()
+	s.enc.Comment(text)
+	return s.flush()
+}
+
+// flush writes the buffer to the peer. The stream is done if that fails.
+// s.mu must be held.
+func (s *Stream) flush() error {
+	if err := s.Err(); err != nil {
+		return err
+	}
+	if s.timeout > 0 {
+		// not all ResponseWriters support deadlines, ignore them then
+		s.rc.SetWriteDeadline(time.Now().Add(s.timeout))
+	}
+	_, err := s.w.Write(s.buf.Bytes())
+	if err == nil {
+		err = s.rc.Flush()
+	}
+	if err != nil {
+		s.cancel(err)
+		return err
+	}
+	s.lastWrite = time.Now()
+	s.bytes += s.buf.Len()
+	return nil
+}
+
+// heartbeat sends an empty comment whenever the stream is idle for d.
+func (s *Stream) heartbeat(d time.Duration) {
+	defer close(s.stopped)
+	t := time.NewTimer(d)
+	defer t.Stop()
+	for {
+		select {
+		case <-s.ctx.Done():
+			return
+		case <-t.C:
+			s.mu.Lock()
+			wait := time.Until(s.lastWrite.Add(d))
+			s.mu.Unlock()
+			if wait > 0 {
+				t.Reset(wait)
+				continue
+			}
+			if s.Comment("") != nil {
+				return
+			}
+			t.Reset(d)
+		}
+	}
+}
+
+// canFlush checks if w, or any ResponseWriter it wraps, can be flushed.
+// It follows the Unwrap convention used by http.ResponseController.
+func canFlush(w http.ResponseWriter) bool {
+	for {
+		switch t := w.(type) {
+		case http.Flusher, interface{ FlushError() error }:
+			return true
+		case interface{ Unwrap() http.ResponseWriter }:
+			w = t.Unwrap()
+		default:
+			return false
+		}
+	}
+}
@@ -0,0 +1,110 @@
+package sse
+
+import (
+	"bufio"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/scale-it/go-web/handlers"
+)
+
+func sendHello(t *testing.T) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		stream, err := NewStream(w, r)
+		if err != nil {
+			t.Error(err)
+			return
+		}
+		if err := stream.Send(&MessageEvent{Data: "hello", Id: "1"}); err != nil {
+			t.Error(err)
+		}
+	}
+}
+
+func TestStreamHTTP2(t *testing.T) {
+	srv := httptest.NewUnstartedServer(sendHello(t))
+	srv.EnableHTTP2 = true
+	srv.StartTLS()
+	defer srv.Close()
+
+	resp, err := srv.Client().Get(srv.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	if resp.ProtoMajor != 2 {
+		t.Fatalf("expected HTTP/2, got %s", resp.Proto)
+	}
+	if got := readEvent(t, bufio.NewReader(resp.Body)); len(got) != 2 || got[1] != "data: hello" {
+		t.Errorf("unexpected event %q", got)
+	}
+}
+
+func TestStreamThroughMiddleware(t *testing.T) {
+	logged := make(chan int, 1)
+	srv := httptest.NewServer(handlers.XHandler{
+		Handler: handlers.Gzip(sendHello(t)),
+		Logger: func(r *http.Request, path string, created time.Time, status, bytes int) {
+			logged <- status
+		},
+	})
+	defer srv.Close()
+
+	// the transport requests and decodes gzip on its own
+	resp, err := http.Get(srv.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	if !resp.Uncompressed {
+		t.Error("expected a gzip encoded response")
+	}
+	if got := readEvent(t, bufio.NewReader(resp.Body)); len(got) != 2 || got[1] != "data: hello" {
+		t.Errorf("unexpected event %q", got)
+	}
+	if status := <-logged; status != http.StatusOK {
+		t.Errorf("expected status 200 to be logged, got %d", status)
+	}
+}
+
+func TestStreamNoFlush(t *testing.T) {
+	w := struct{ http.ResponseWriter }{httptest.NewRecorder()}
+	if _, err := NewStream(w, httptest.NewRequest("GET", "/", nil)); err != ErrNoFlush {
+		t.Errorf("expected ErrNoFlush, got %v", err)
+	}
+}
+
+func TestStreamHeartbeat(t *testing.T) {
+	done := make(chan struct{})
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		stream, err := StreamConfig{Heartbeat: 10 * time.Millisecond}.NewStream(w, r)
+		if err != nil {
+			t.Error(err)
+			return
+		}
+		defer stream.Close()
+		<-stream.Done()
+		close(done)
+	}))
+	defer srv.Close()
+
+	resp, err := http.Get(srv.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	r := bufio.NewReader(resp.Body)
+	for i := 0; i < 2; i++ {
+		if l, err := r.ReadString('\n'); err != nil || l != ":\n" {
+			t.Fatalf("expected heartbeat, got %q %v", l, err)
+		}
+	}
+	// Done is closed once the peer disconnects
+	resp.Body.Close()
+	select {
+	case <-done:
+	case <-time.After(5 * time.Second):
+		t.Fatal("stream not done after disconnect")
+	}
+}
@@ -0,0 +1,198 @@
+package websocket
+
+import (
+	"net/http"
+	"sync"
+	"time"
+)
+
+// DefaultQueueSize is the number of messages queued for a connection when
+// Broker.QueueSize is 0.
+const DefaultQueueSize = 16
+
+// Broker fans out messages to all WebSocket connections subscribed to a
+// topic, like sse.Broker, and passes the messages sent by the peers to
+// OnMessage. The zero value is ready to use. The configuration fields must
+// not be modified once the Broker is in use.
+//
+// Usage example:
+//
+//	var broker = websocket.Broker{
+//	        OnMessage: func(c *websocket.Conn, r *http.Request, topic string, t websocket.MessageType, msg []byte) {
+//	                broker.Publish(topic, t, msg) // a chat room
+//	        },
+//	}
+//
+//	func main() {
+//	        http.Handle("/chat", broker.Handler("chat"))
+//	        http.ListenAndServe(":8080", nil)
+//	}
+type Broker struct {
+	// Upgrader configures the handshake.
+	Upgrader Upgrader
+	// QueueSize is the number of messages queued for a connection which
+	// does not keep up with the publishers, DefaultQueueSize if 0. When the
+	// queue is full the connection is closed with CloseTryAgainLater.
+	QueueSize int
+	// PingInterval is the interval of the pings sent to detect dead peers.
+	// A peer which does not answer within two intervals is disconnected.
+	// Pings are disabled if 0.
+	PingInterval time.Duration
+	// WriteTimeout, if set, is the maximum time a write may take.
+	WriteTimeout time.Duration
+	// OnMessage, if set, is called with every message sent by a peer.
+	// It is called from the goroutine reading the connection.
+	OnMessage func(c *Conn, r *http.Request, topic string, t MessageType, msg []byte)
+
+	mu     sync.Mutex
+	topics map[string]map[*subscriber]struct{}
+}
+
+type message struct {
+	t    MessageType
+	data []byte
+}
+
+type subscriber struct {
+	conn  *Conn
+	queue chan message
+	kick  chan struct{}
+	once  sync.Once
+}
+
+// Handler returns an http.Handler which subscribes every request to the topic.
+// The handshake errors are already answered by Subscribe.
+func (b *Broker) Handler(topic string) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		b.Subscribe(w, r, topic)
+	})
+}
+
+// Subscribe upgrades the request and delivers every message published to
+// the topic, until the connection is closed. An error is returned only if
+// the handshake fails, in which case the error response is already sent.
+func (b *Broker) Subscribe(w http.ResponseWriter, r *http.Request, topic string) error {
+	conn, err := b.Upgrader.Upgrade(w, r)
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+
+	size := b.QueueSize
+	if size <= 0 {
+		size = DefaultQueueSize
+	}
+	s := &subscriber{
+		conn:  conn,
+		queue: make(chan message, size),
+		kick:  make(chan struct{}),
+	}
+	b.mu.Lock()
+	if b.topics == nil {
+		b.topics = make(map[string]map[*subscriber]struct{})
+	}
+	if b.topics[topic] == nil {
+		b.topics[topic] = make(map[*subscriber]struct{})
+	}
+	b.topics[topic][s] = struct{}{}
+	b.mu.Unlock()
+
+	done := make(chan struct{})
+	var wg sync.WaitGroup
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		b.write(s, done)
+	}()
+	defer func() {
+		b.unsubscribe(topic, s)
+		close(done)
+		wg.Wait()
+	}()
+
+	if b.PingInterval > 0 {
+		conn.SetReadDeadline(time.Now().Add(2 * b.PingInterval))
+		conn.SetPongHandler(func([]byte) {
+			conn.SetReadDeadline(time.Now().Add(2 * b.PingInterval))
+		})
+	}
+	for {
+		t, msg, err := conn.ReadMessage()
+		if err != nil {
+			return nil
+		}
+		if b.OnMessage != nil {
+			b.OnMessage(conn, r, topic, t, msg)
+		}
+	}
+}
+
+// write sends the queued messages and the pings of a connection.
+func (b *Broker) write(s *subscriber, done chan struct{}) {
+	var ping <-chan time.Time
+	if b.PingInterval > 0 {
+		t := time.NewTicker(b.PingInterval)
+		defer t.Stop()
+		ping = t.C
+	}
+	for {
+		var err error
+		select {
+		case m := <-s.queue:
+			b.writeDeadline(s.conn)
+			err = s.conn.WriteMessage(m.t, m.data)
+		case <-ping:
+			b.writeDeadline(s.conn)
+			err = s.conn.Ping(nil)
+		case <-s.kick:
+			b.writeDeadline(s.conn)
+			s.conn.WriteClose(CloseTryAgainLater, "slow consumer")
+			s.conn.conn.Close()
+			return
+		case <-done:
+			return
+		}
+		if err != nil {
+			// unblocks ReadMessage
+			s.conn.conn.Close()
+			return
+		}
+	}
+}
+
+func (b *Broker) writeDeadline(c *Conn) {
+	if b.WriteTimeout > 0 {
+		c.SetWriteDeadline(time.Now().Add(b.WriteTimeout))
+	}
+}
+
+// Publish sends the message to all connections subscribed to the topic.
+// Connections whose queue is full are closed.
+func (b *Broker) Publish(topic string, t MessageType, data []byte) {
+	m := message{t, data}
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	for s := range b.topics[topic] {
+		select {
+		case s.queue <- m:
+		default:
+			s.once.Do(func() { close(s.kick) })
+		}
+	}
+}
+
+// Subscribers returns the number of connections subscribed to the topic.
+func (b *Broker) Subscribers(topic string) int {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return len(b.topics[topic])
+}
+
+func (b *Broker) unsubscribe(topic string, s *subscriber) {
+	b.mu.Lock()
+	delete(b.topics[topic], s)
+	if len(b.topics[topic]) == 0 {
+		delete(b.topics, topic)
+	}
+	b.mu.Unlock()
+}
@@ -0,0 +1,400 @@
+package websocket
+
+import (
+	"bufio"
+	"crypto/rand"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"sync"
+	"time"
+	"unicode/utf8"
+)
+
+// MessageType is the type of a data message.
+type MessageType int
+
+const (
+	TextMessage   MessageType = 1
+	BinaryMessage MessageType = 2
+)
+
+// frame opcodes
+const (
+	opContinuation = 0x0
+	opText         = 0x1
+	opBinary       = 0x2
+	opClose        = 0x8
+	opPing         = 0x9
+	opPong         = 0xa
+)
+
+// Close codes, RFC 6455 section 7.4.1.
+const (
+	CloseNormalClosure           = 1000
+	CloseGoingAway               = 1001
+	CloseProtocolError           = 1002
+	CloseUnsupportedData         = 1003
+	CloseNoStatusReceived        = 1005 // never sent, reported when the close frame has no code
+	CloseAbnormalClosure         = 1006 // never sent
+	CloseInvalidFramePayloadData = 1007
+	ClosePolicyViolation         = 1008
+	CloseMessageTooBig           = 1009
+	CloseMandatoryExtension      = 1010
+	CloseInternalServerErr       = 1011
+	CloseTryAgainLater           = 1013
+)
+
+// maxControlPayload is the maximum payload of control frames.
+const maxControlPayload = 125
+
+// FragmentSize is the size of the fragments written by the writers returned
+// by Conn.NextWriter.
+const FragmentSize = 4096
+
+var ErrClosed = errors.New("WebSocket close frame already sent")
+
+// CloseError is returned by Conn.ReadMessage when the connection is closed,
+// by the peer or because of a protocol violation.
+type CloseError struct {
+	Code int
+	Text string
+}
+
+func (e *CloseError) Error() string {
+	return fmt.Sprintf("WebSocket closed with code %d %s", e.Code, e.Text)
+}
+
+// Conn is a WebSocket connection. Reads must be done from a single
+// goroutine; writes are safe for concurrent use, and control frames (ping,
+// pong, close) may be written while a fragmented message is being written.
+type Conn struct {
+	conn        net.Conn
+	br          *bufio.Reader
+	server      bool
+	readLimit   int64
+	subprotocol string
+	pongHandler func(data []byte)
+
+	mmu sync.Mutex // held while a data message is written
+
+	wmu       sync.Mutex // held while a frame is written
+	closeSent bool
+}
+
+func newConn(conn net.Conn, br *bufio.Reader, server bool, readLimit int64) *Conn {
+	if br == nil {
+		br = bufio.NewReader(conn)
+	}
+	return &Conn{conn: conn, br: br, server: server, readLimit: readLimit}
+}
+
+// Subprotocol returns the subprotocol negotiated during the handshake.
+func (c *Conn) Subprotocol() string {
+	return c.subprotocol
+}
+
+// RemoteAddr returns the network address of the peer.
+func (c *Conn) RemoteAddr() net.Addr {
+	return c.conn.RemoteAddr()
+}
+
+// SetReadDeadline sets the deadline of the reads of the connection.
+func (c *Conn) SetReadDeadline(t time.Time) error {
+	return c.conn.SetReadDeadline(t)
+}
+
+// SetWriteDeadline sets the deadline of the writes of the connection.
+func (c *Conn) SetWriteDeadline(t time.Time) error {
+	return c.conn.SetWriteDeadline(t)
+}
+
+// SetPongHandler sets the function called by ReadMessage for every pong
+// received. It is usually used to extend the read deadline.
+func (c *Conn) SetPongHandler(h func(data []byte)) {
+	c.pongHandler = h
+}
+
+// ReadMessage reads the next data message, joining its fragments. Pings
+// are answered and close frames echoed, after which a *CloseError is
+// returned. A protocol violation by the peer closes the connection with the
+// appropriate close code, and a *CloseError is returned too.
+func (c *Conn) ReadMessage() (MessageType, []byte, error) {
+	var (
+		t   MessageType
+		msg []byte
+	)
+	for {
+		fin, op, payload, err := c.readFrame(c.readLimit - int64(len(msg)))
+		if err != nil {
+			return 0, nil, c.fail(err)
+		}
+		switch op {
+		case opPing:
+			if err := c.writeFrame(true, opPong, payload); err != nil && err != ErrClosed {
+				return 0, nil, c.fail(err)
+			}
+			continue
+		case opPong:
+			if c.pongHandler != nil {
+				c.pongHandler(payload)
+			}
+			continue
+		case opClose:
+			return 0, nil, c.handleClose(payload)
+		case opContinuation:
+			if t == 0 {
+				return 0, nil, c.fail(protocolError("continuation frame without a message"))
+			}
+		case opText, opBinary:
+			if t != 0 {
+				return 0, nil, c.fail(protocolError("data frame inside a fragmented message"))
+			}
+			t = MessageType(op)
+		default:
+			return 0, nil, c.fail(protocolError(fmt.Sprintf("reserved opcode %d", op)))
+		}
+		msg = append(msg, payload...)
+		if fin {
+			if t == TextMessage && !utf8.Valid(msg) {
+				return 0, nil, c.fail(&CloseError{CloseInvalidFramePayloadData, "invalid UTF-8 text message"})
+			}
+			return t, msg, nil
+		}
+	}
+}
+
+// WriteMessage writes a data message in a single frame.
+func (c *Conn) WriteMessage(t MessageType, data []byte) error {
+	c.mmu.Lock()
+	defer c.mmu.Unlock()
+	return c.writeFrame(true, byte(t), data)
+}
+
+// NextWriter returns a writer of a fragmented message, for messages whose
+// size is not known in advance. The message is sent in fragments of
+// FragmentSize and ends when the writer is closed. Other data messages
+// wait until then.
+func (c *Conn) NextWriter(t MessageType) io.WriteCloser {
+	c.mmu.Lock()
+	return &messageWriter{c: c, op: byte(t)}
+}
+
+// Ping sends a ping, which the peer answers with a pong.
+func (c *Conn) Ping(data []byte) error {
+	if len(data) > maxControlPayload {
+		return errors.New("WebSocket control frame payload too long")
+	}
+	return c.writeFrame(true, opPing, data)
+}
+
+// WriteClose starts the closing handshake. ReadMessage then returns once the
+// peer answers with its own close frame.
+func (c *Conn) WriteClose(code int, reason string) error {
+	payload := make([]byte, 2, 2+len(reason))
+	binary.BigEndian.PutUint16(payload, uint16(code))
+	payload = append(payload, reason...)
+	if len(payload) > maxControlPayload {
+		payload = payload[:maxControlPayload]
+	}
+	return c.writeFrame(true, opClose, payload)
+}
+
+// Close sends a normal closure frame, unless one was sent already, and
+// closes the connection without waiting for the answer of the peer.
+func (c *Conn) Close() error {
+	c.WriteClose(CloseNormalClosure, "")
+	return c.conn.Close()
+}
+
+// protocolError closes the connection with CloseProtocolError.
+func protocolError(text string) error {
+	return &CloseError{CloseProtocolError, text}
+}
+
+// fail closes the connection after an error. Protocol violations (a
+// *CloseError) are reported to the peer.
+func (c *Conn) fail(err error) error {
+	if ce, ok := err.(*CloseError); ok {
+		c.WriteClose(ce.Code, ce.Text)
+	}
+	c.conn.Close()
+	return err
+}
+
+// handleClose answers a close frame and closes the connection.
+func (c *Conn) handleClose(payload []byte) error {
+	ce := &CloseError{Code: CloseNoStatusReceived}
+	switch {
+	case len(payload) == 1:
+		return c.fail(protocolError("invalid close frame payload"))
+	case len(payload) >= 2:
+		ce.Code = int(binary.BigEndian.Uint16(payload))
+		ce.Text = string(payload[2:])
+		if !validCloseCode(ce.Code) || !utf8.Valid(payload[2:]) {
+			return c.fail(protocolError("invalid close frame payload"))
+		}
+		c.writeFrame(true, opClose, payload[:2])
+	default:
+		c.writeFrame(true, opClose, nil)
+	}
+	c.conn.Close()
+	return ce
+}
+
+func validCloseCode(code int) bool {
+	switch {
+	case code >= 1000 && code <= 1003, code >= 1007 && code <= 1011, code == CloseTryAgainLater:
+		return true
+	}
+	return code >= 3000 && code <= 4999
+}
+
+// readFrame reads a single frame, unmasking its payload.
+func (c *Conn) readFrame(limit int64) (fin bool, op byte, payload []byte, err error) {
+	var h [8]byte
+	if _, err = io.ReadFull(c.br, h[:2]); err != nil {
+		return
+	}
+	fin, op = h[0]&0x80 != 0, h[0]&0x0f
+	if h[0]&0x70 != 0 {
+		err = protocolError("reserved bits set without an extension")
+		return
+	}
+	masked := h[1]&0x80 != 0
+	if masked != c.server {
+		err = protocolError("invalid frame masking")
+		return
+	}
+	n := int64(h[1] & 0x7f)
+	switch n {
+	case 126:
+		if _, err = io.ReadFull(c.br, h[:2]); err != nil {
+			return
+		}
+		n = int64(binary.BigEndian.Uint16(h[:2]))
+	case 127:
+		if _, err = io.ReadFull(c.br, h[:8]); err != nil {
+			return
+		}
+		if h[0]&0x80 != 0 {
+			err = protocolError("invalid frame length")
+			return
+		}
+		n = int64(binary.BigEndian.Uint64(h[:8]))
+	}
+	if op >= opClose {
+		if !fin || n > maxControlPayload {
+			err = protocolError("invalid control frame")
+			return
+		}
+	} else if n > limit {
+		err = &CloseError{CloseMessageTooBig, "message too big"}
+		return
+	}
+	var mask [4]byte
+	if masked {
+		if _, err = io.ReadFull(c.br, mask[:]); err != nil {
+			return
+		}
+	}
+	payload = make([]byte, n)
+	if _, err = io.ReadFull(c.br, payload); err != nil {
+		return
+	}
+	if masked {
+		maskBytes(mask, payload)
+	}
+	return
+}
+
+// writeFrame writes a single frame, masking it on the client side.
+func (c *Conn) writeFrame(fin bool, op byte, payload []byte) error {
+	c.wmu.Lock()
+	defer c.wmu.Unlock()
+	if c.closeSent {
+		return ErrClosed
+	}
+	if op == opClose {
+		c.closeSent = true
+	}
+
+	b := make([]byte, 2, 14+len(payload))
+	b[0] = op
+	if fin {
+		b[0] |= 0x80
+	}
+	switch n := len(payload); {
+	case n <= 125:
+		b[1] = byte(n)
+	case n <= 0xffff:
+		b[1] = 126
+		b = binary.BigEndian.AppendUint16(b, uint16(n))
+	default:
+		b[1] = 127
+		b = binary.BigEndian.AppendUint64(b, uint64(n))
+	}
+	if c.server {
+		b = append(b, payload...)
+	} else {
+		var mask [4]byte
+		if _, err := rand.Read(mask[:]); err != nil {
+			return err
+		}
+		b[1] |= 0x80
+		b = append(b, mask[:]...)
+		b = append(b, payload...)
+		maskBytes(mask, b[len(b)-len(payload):])
+	}
+	_, err := c.conn.Write(b)
+	return err
+}
+
+func maskBytes(mask [4]byte, b []byte) {
+	for i := range b {
+		b[i] ^= mask[i%4]
+	}
+}
+
+// messageWriter writes a message in fragments.
+type messageWriter struct {
+	c      *Conn
+	op     byte // opcode of the next fragment
+	buf    []byte
+	closed bool
+}
+
+func (w *messageWriter) Write(p []byte) (int, error) {
+	if w.closed {
+		return 0, ErrClosed
+	}
+	n := len(p)
+	for len(w.buf)+len(p) > FragmentSize {
+		k := FragmentSize - len(w.buf)
+		w.buf = append(w.buf, p[:k]...)
+		p = p[k:]
+		if err := w.flush(false); err != nil {
+			return n - len(p), err
+		}
+	}
+	w.buf = append(w.buf, p...)
+	return n, nil
+}
+
+func (w *messageWriter) flush(fin bool) error {
+	err := w.c.writeFrame(fin, w.op, w.buf)
+	w.op, w.buf = opContinuation, w.buf[:0]
+	return err
+}
+
+// Close writes the last fragment.
+func (w *messageWriter) Close() error {
+	if w.closed {
+		return nil
+	}
+	w.closed = true
+	defer w.c.mmu.Unlock()
+	return w.flush(true)
+}
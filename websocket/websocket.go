@@ -0,0 +1,178 @@
+// WebSocket protocol (RFC 6455), server side.
+// https://tools.ietf.org/html/rfc6455
+//
+// The connection is upgraded through the http.ResponseWriter, so the
+// response status (101) is accounted for by handlers.WrapWriter and logged
+// by handlers.XHandler, like any other response.
+//
+// Usage example:
+//
+//	func EchoHandler(w http.ResponseWriter, r *http.Request) {
+//	        conn, err := websocket.Upgrade(w, r)
+//	        if err != nil {
+//	                return // the error response is already sent
+//	        }
+//	        defer conn.Close()
+//	        for {
+//	                t, msg, err := conn.ReadMessage()
+//	                if err != nil {
+//	                        return
+//	                }
+//	                conn.WriteMessage(t, msg)
+//	        }
+//	}
+package websocket
+
+import (
+	"crypto/sha1"
+	"encoding/base64"
+	"errors"
+	"net/http"
+	"net/url"
+	"strings"
+)
+
+var (
+	ErrBadHandshake = errors.New("Not a valid WebSocket handshake")
+	ErrBadOrigin    = errors.New("WebSocket request origin not allowed")
+	ErrNoHijack     = errors.New("Server does not support hijacking")
+)
+
+// DefaultReadLimit is the maximum size of a message when
+// Upgrader.ReadLimit is 0.
+const DefaultReadLimit = 1 << 20
+
+// keyGUID is concatenated to Sec-WebSocket-Key to compute the accept key.
+const keyGUID = "258EAFA5-E914-47DA-95CA-C5AB0DC85B11"
+
+// Upgrader upgrades HTTP requests to WebSocket connections.
+type Upgrader struct {
+	// CheckOrigin accepts or rejects the request based on its Origin header.
+	// If nil, requests with an Origin header are accepted only if its host
+	// is the request Host (the same-origin policy).
+	CheckOrigin func(r *http.Request) bool
+	// Subprotocols are the supported subprotocols, in order of preference.
+	Subprotocols []string
+	// ReadLimit is the maximum size of a message, DefaultReadLimit if 0.
+	ReadLimit int64
+}
+
+// Upgrade upgrades the request with the default Upgrader.
+func Upgrade(w http.ResponseWriter, r *http.Request) (*Conn, error) {
+	return Upgrader{}.Upgrade(w, r)
+}
+
+// Upgrade validates the handshake and upgrades the connection. If that
+// fails, an HTTP error response is sent and the error returned.
+func (u Upgrader) Upgrade(w http.ResponseWriter, r *http.Request) (*Conn, error) {
+	if r.Method != "GET" ||
+		!headerContains(r.Header, "Connection", "upgrade") ||
+		!headerContains(r.Header, "Upgrade", "websocket") {
+		http.Error(w, ErrBadHandshake.Error(), http.StatusBadRequest)
+		return nil, ErrBadHandshake
+	}
+	if r.Header.Get("Sec-WebSocket-Version") != "13" {
+		w.Header().Set("Sec-WebSocket-Version", "13")
+		http.Error(w, "Unsupported WebSocket version", http.StatusUpgradeRequired)
+		return nil, ErrBadHandshake
+	}
+	key := r.Header.Get("Sec-WebSocket-Key")
+	if k, err := base64.StdEncoding.DecodeString(key); err != nil || len(k) != 16 {
+		http.Error(w, ErrBadHandshake.Error(), http.StatusBadRequest)
+		return nil, ErrBadHandshake
+	}
+	checkOrigin := u.CheckOrigin
+	if checkOrigin == nil {
+		checkOrigin = sameOrigin
+	}
+	if !checkOrigin(r) {
+		http.Error(w, ErrBadOrigin.Error(), http.StatusForbidden)
+		return nil, ErrBadOrigin
+	}
+	if !canHijack(w) {
+		http.Error(w, ErrNoHijack.Error(), http.StatusInternalServerError)
+		return nil, ErrNoHijack
+	}
+
+	h := w.Header()
+	h.Del("Content-Encoding") // the connection is not HTTP anymore
+	h.Set("Upgrade", "websocket")
+	h.Set("Connection", "Upgrade")
+	h.Set("Sec-WebSocket-Accept", acceptKey(key))
+	subprotocol := u.selectSubprotocol(r)
+	if subprotocol != "" {
+		h.Set("Sec-WebSocket-Protocol", subprotocol)
+	}
+	// The status is sent by Hijack, through the wrapping ResponseWriters.
+	w.WriteHeader(http.StatusSwitchingProtocols)
+	conn, buf, err := http.NewResponseController(w).Hijack()
+	if err != nil {
+		return nil, err
+	}
+	limit := u.ReadLimit
+	if limit <= 0 {
+		limit = DefaultReadLimit
+	}
+	c := newConn(conn, buf.Reader, true, limit)
+	c.subprotocol = subprotocol
+	return c, nil
+}
+
+func (u Upgrader) selectSubprotocol(r *http.Request) string {
+	var requested []string
+	for _, v := range r.Header.Values("Sec-WebSocket-Protocol") {
+		for _, p := range strings.Split(v, ",") {
+			requested = append(requested, strings.TrimSpace(p))
+		}
+	}
+	for _, p := range u.Subprotocols {
+		for _, q := range requested {
+			if p == q {
+				return p
+			}
+		}
+	}
+	return ""
+}
+
+// canHijack checks if w, or any ResponseWriter it wraps, can be hijacked.
+// It follows the Unwrap convention used by http.ResponseController.
+func canHijack(w http.ResponseWriter) bool {
+	for {
+		switch t := w.(type) {
+		case http.Hijacker:
+			return true
+		case interface{ Unwrap() http.ResponseWriter }:
+			w = t.Unwrap()
+		default:
+			return false
+		}
+	}
+}
+
+func acceptKey(key string) string {
+	h := sha1.New()
+	h.Write([]byte(key + keyGUID))
+	return base64.StdEncoding.EncodeToString(h.Sum(nil))
+}
+
+// headerContains checks if the comma separated header contains the token.
+func headerContains(h http.Header, name, token string) bool {
+	for _, v := range h.Values(name) {
+		for _, t := range strings.Split(v, ",") {
+			if strings.EqualFold(strings.TrimSpace(t), token) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+func sameOrigin(r *http.Request) bool {
+	origin := r.Header.Get("Origin")
+	if origin == "" {
+		return true
+	}
+	u, err := url.Parse(origin)
+	return err == nil && strings.EqualFold(u.Host, r.Host)
+}
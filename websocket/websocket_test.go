@@ -0,0 +1,271 @@
+package websocket
+
+import (
+	"bufio"
+	"bytes"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/scale-it/go-web/handlers"
+)
+
+// dial performs the client side of the handshake.
+func dial(t *testing.T, srv *httptest.Server, header http.Header) (*Conn, *http.Response) {
+	t.Helper()
+	c, err := net.Dial("tcp", srv.Listener.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { c.Close() })
+	req, _ := http.NewRequest("GET", srv.URL, nil)
+	req.Header.Set("Connection", "Upgrade")
+	req.Header.Set("Upgrade", "websocket")
+	req.Header.Set("Sec-WebSocket-Version", "13")
+	req.Header.Set("Sec-WebSocket-Key", "dGhlIHNhbXBsZSBub25jZQ==")
+	for k, v := range header {
+		req.Header[k] = v
+	}
+	if err := req.Write(c); err != nil {
+		t.Fatal(err)
+	}
+	br := bufio.NewReader(c)
+	resp, err := http.ReadResponse(br, req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if resp.StatusCode != http.StatusSwitchingProtocols {
+		return nil, resp
+	}
+	c.SetDeadline(time.Now().Add(5 * time.Second))
+	return newConn(c, br, false, DefaultReadLimit), resp
+}
+
+func echo(u Upgrader) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		conn, err := u.Upgrade(w, r)
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+		for {
+			mt, msg, err := conn.ReadMessage()
+			if err != nil {
+				return
+			}
+			conn.WriteMessage(mt, msg)
+		}
+	}
+}
+
+func TestHandshake(t *testing.T) {
+	srv := httptest.NewServer(echo(Upgrader{Subprotocols: []string{"v2", "v1"}}))
+	defer srv.Close()
+
+	conn, resp := dial(t, srv, http.Header{"Sec-Websocket-Protocol": {"v1, v2"}})
+	if conn == nil {
+		t.Fatalf("unexpected status %d", resp.StatusCode)
+	}
+	// the example of RFC 6455 section 1.3
+	if got := resp.Header.Get("Sec-WebSocket-Accept"); got != "s3pPLMBiTxaQ9kYGzzhZRbK+xOo=" {
+		t.Errorf("unexpected accept key %q", got)
+	}
+	if got := resp.Header.Get("Sec-WebSocket-Protocol"); got != "v2" {
+		t.Errorf("expected subprotocol v2, got %q", got)
+	}
+
+	for _, c := range []struct {
+		header http.Header
+		status int
+	}{
+		{http.Header{"Upgrade": {"h2c"}}, http.StatusBadRequest},
+		{http.Header{"Sec-Websocket-Key": {"short"}}, http.StatusBadRequest},
+		{http.Header{"Sec-Websocket-Version": {"8"}}, http.StatusUpgradeRequired},
+		{http.Header{"Origin": {"http://example.com"}}, http.StatusForbidden},
+	} {
+		if _, resp := dial(t, srv, c.header); resp.StatusCode != c.status {
+			t.Errorf("%v: expected status %d, got %d", c.header, c.status, resp.StatusCode)
+		}
+	}
+}
+
+func TestEcho(t *testing.T) {
+	srv := httptest.NewServer(echo(Upgrader{}))
+	defer srv.Close()
+	conn, _ := dial(t, srv, nil)
+
+	if err := conn.WriteMessage(TextMessage, []byte("hello")); err != nil {
+		t.Fatal(err)
+	}
+	if mt, msg, err := conn.ReadMessage(); err != nil || mt != TextMessage || string(msg) != "hello" {
+		t.Errorf("unexpected message %d %q %v", mt, msg, err)
+	}
+
+	// fragmented, with a ping in between the fragments
+	big := bytes.Repeat([]byte("0123456789"), FragmentSize)
+	w := conn.NextWriter(BinaryMessage)
+	w.Write(big[:FragmentSize+1])
+	if err := conn.Ping([]byte("ping")); err != nil {
+		t.Fatal(err)
+	}
+	w.Write(big[FragmentSize+1:])
+	if err := w.Close(); err != nil {
+		t.Fatal(err)
+	}
+	pong := make(chan string, 1)
+	conn.SetPongHandler(func(data []byte) { pong <- string(data) })
+	if mt, msg, err := conn.ReadMessage(); err != nil || mt != BinaryMessage || !bytes.Equal(msg, big) {
+		t.Errorf("unexpected message %d of %d bytes: %v", mt, len(msg), err)
+	}
+	select {
+	case p := <-pong:
+		if p != "ping" {
+			t.Errorf("unexpected pong %q", p)
+		}
+	default:
+		t.Error("expected a pong")
+	}
+
+	if err := conn.WriteClose(CloseGoingAway, "bye"); err != nil {
+		t.Fatal(err)
+	}
+	_, _, err := conn.ReadMessage()
+	if ce, ok := err.(*CloseError); !ok || ce.Code != CloseGoingAway {
+		t.Errorf("expected the close frame to be echoed, got %v", err)
+	}
+}
+
+func TestProtocolErrors(t *testing.T) {
+	srv := httptest.NewServer(echo(Upgrader{ReadLimit: 10}))
+	defer srv.Close()
+
+	for _, c := range []struct {
+		name string
+		send func(c *Conn) error
+		code int
+	}{
+		{"invalid UTF-8", func(c *Conn) error {
+			return c.WriteMessage(TextMessage, []byte{0xff, 0xfe})
+		}, CloseInvalidFramePayloadData},
+		{"too big", func(c *Conn) error {
+			return c.WriteMessage(BinaryMessage, []byte(strings.Repeat("x", 11)))
+		}, CloseMessageTooBig},
+		{"continuation", func(c *Conn) error {
+			return c.writeFrame(true, opContinuation, []byte("x"))
+		}, CloseProtocolError},
+		{"reserved opcode", func(c *Conn) error {
+			return c.writeFrame(true, 0x3, nil)
+		}, CloseProtocolError},
+		{"fragmented ping", func(c *Conn) error {
+			return c.writeFrame(false, opPing, nil)
+		}, CloseProtocolError},
+		{"invalid close code", func(c *Conn) error {
+			return c.WriteClose(1005, "")
+		}, CloseProtocolError},
+	} {
+		conn, _ := dial(t, srv, nil)
+		if err := c.send(conn); err != nil {
+			t.Fatal(err)
+		}
+		// the server answers with a close frame
+		_, _, err := conn.ReadMessage()
+		if ce, ok := err.(*CloseError); !ok || ce.Code != c.code {
+			t.Errorf("%s: expected close code %d, got %v", c.name, c.code, err)
+		}
+	}
+}
+
+func TestUnmaskedFrame(t *testing.T) {
+	srv := httptest.NewServer(echo(Upgrader{}))
+	defer srv.Close()
+	conn, _ := dial(t, srv, nil)
+
+	conn.server = true // sends unmasked frames
+	conn.WriteMessage(TextMessage, []byte("hello"))
+	conn.server = false
+	_, _, err := conn.ReadMessage()
+	if ce, ok := err.(*CloseError); !ok || ce.Code != CloseProtocolError {
+		t.Errorf("expected a protocol error, got %v", err)
+	}
+}
+
+func TestXHandlerLogs(t *testing.T) {
+	logged := make(chan int, 1)
+	srv := httptest.NewServer(handlers.XHandler{
+		Handler: echo(Upgrader{}),
+		Logger: func(r *http.Request, path string, created time.Time, status, bytes int) {
+			logged <- status
+		},
+	})
+	defer srv.Close()
+
+	conn, resp := dial(t, srv, nil)
+	if conn == nil {
+		t.Fatalf("unexpected status %d", resp.StatusCode)
+	}
+	conn.Close()
+	if status := <-logged; status != http.StatusSwitchingProtocols {
+		t.Errorf("expected status 101 to be logged, got %d", status)
+	}
+}
+
+func TestBroker(t *testing.T) {
+	var b Broker
+	b.OnMessage = func(c *Conn, r *http.Request, topic string, mt MessageType, msg []byte) {
+		b.Publish(topic, mt, msg)
+	}
+	srv := httptest.NewServer(b.Handler("chat"))
+	defer srv.Close()
+
+	var conns []*Conn
+	for i := 0; i < 3; i++ {
+		conn, _ := dial(t, srv, nil)
+		conns = append(conns, conn)
+	}
+	for b.Subscribers("chat") != 3 {
+		time.Sleep(time.Millisecond)
+	}
+
+	conns[0].WriteMessage(TextMessage, []byte("hello"))
+	for i, c := range conns {
+		if _, msg, err := c.ReadMessage(); err != nil || string(msg) != "hello" {
+			t.Errorf("connection %d: unexpected message %q %v", i, msg, err)
+		}
+	}
+
+	conns[1].Close()
+	for b.Subscribers("chat") != 2 {
+		time.Sleep(time.Millisecond)
+	}
+}
+
+func TestBrokerSlowConsumer(t *testing.T) {
+	b := Broker{QueueSize: 1}
+	srv := httptest.NewServer(b.Handler("news"))
+	defer srv.Close()
+
+	conn, _ := dial(t, srv, nil)
+	for b.Subscribers("news") != 1 {
+		time.Sleep(time.Millisecond)
+	}
+	// the client does not read, so the queue fills up eventually
+	msg := bytes.Repeat([]byte("x"), 1<<16)
+	for b.Subscribers("news") == 1 {
+		b.Publish("news", BinaryMessage, msg)
+	}
+	for {
+		_, _, err := conn.ReadMessage()
+		if ce, ok := err.(*CloseError); ok {
+			if ce.Code != CloseTryAgainLater {
+				t.Errorf("unexpected close code %d", ce.Code)
+			}
+			break
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+}